@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestPreserveANSI(t *testing.T) {
+	in := "\033[34mfoo\033[0m/\033[32mbar.go\033[0m\n"
+
+	got, _, _ := runFz(t, in, "-preserve-ansi", "bar")
+	want := "\033[34mfoo\033[0m/\033[32m\033[1mbar\033[0m\033[32m.go\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// A match spanning an escape keeps the escape and re-opens the
+	// highlight after it.
+	got, _, _ = runFz(t, in, "-preserve-ansi", "o/b")
+	want = "\033[34mfo\033[1mo\033[0m\033[1m/\033[32m\033[1mb\033[0m\033[32mar.go\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Without highlighting, the input's own escapes are still kept.
+	got, _, _ = runFz(t, in, "-preserve-ansi", "-color", "never", "bar")
+	if got != in {
+		t.Errorf("got output %q without highlighting, want %q", got, in)
+	}
+}
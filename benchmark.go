@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gcurtis/fz/fuzzy"
+)
+
+// runBenchmark appends corpus to s, ranks up to max results and writes metrics
+// about the run to w. Each metric is written on its own line as a name and a value
+// separated by a space.
+func runBenchmark(w io.Writer, s *fuzzy.Searcher, corpus []string, max int) {
+	bytes := 0
+	for _, c := range corpus {
+		bytes += len(c)
+	}
+
+	start := time.Now()
+	for _, c := range corpus {
+		s.Append(c)
+	}
+	results := s.RankedResults(max)
+	elapsed := time.Since(start)
+
+	secs := elapsed.Seconds()
+	fmt.Fprintf(w, "lines %d\n", len(corpus))
+	fmt.Fprintf(w, "bytes %d\n", bytes)
+	fmt.Fprintf(w, "jobs %d\n", s.Jobs)
+	fmt.Fprintf(w, "batches %d\n", s.Batches())
+	fmt.Fprintf(w, "matches %d\n", s.Matched())
+	fmt.Fprintf(w, "results %d\n", len(results))
+	fmt.Fprintf(w, "elapsed_ns %d\n", elapsed.Nanoseconds())
+	fmt.Fprintf(w, "lines_per_sec %.0f\n", float64(len(corpus))/secs)
+	fmt.Fprintf(w, "bytes_per_sec %.0f\n", float64(bytes)/secs)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestBenchmarkCommand(t *testing.T) {
+	got, _, code := runFz(t, "", "-benchmark", "-bench-lines", "5000", "-bench-length", "100", "-jobs", "2", "-batch-bytes", "100000")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+
+	metrics := make(map[string]float64)
+	for _, l := range strings.Split(strings.TrimSpace(got), "\n") {
+		fields := strings.Fields(l)
+		if len(fields) != 2 {
+			t.Fatalf("got malformed metric line %q", l)
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("got non-numeric metric line %q", l)
+		}
+		metrics[fields[0]] = v
+	}
+
+	for name, want := range map[string]float64{
+		"lines":   5000,
+		"bytes":   500000,
+		"jobs":    2,
+		"batches": 5,
+		"matches": 5000,
+		"results": maxResults,
+	} {
+		if metrics[name] != want {
+			t.Errorf("got %s %v, want %v", name, metrics[name], want)
+		}
+	}
+	if metrics["batches"] < 1 {
+		t.Errorf("got %v batches, want enough input to batch", metrics["batches"])
+	}
+	for _, name := range []string{"elapsed_ns", "lines_per_sec", "bytes_per_sec"} {
+		if metrics[name] <= 0 {
+			t.Errorf("got %s %v, want a positive value", name, metrics[name])
+		}
+	}
+
+	if _, _, code := runFz(t, "", "-benchmark", "-bench-length", "1"); code != 2 {
+		t.Errorf("got exit code %d for a too short input length, want 2", code)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cachedLines reads r in full and returns its lines, which parse splits it into
+// when they aren't cached. The parsed lines are cached in dir under a hash of
+// the bytes that were read, so later calls with identical input load them from
+// the cache rather than parsing it again, and any change to the input misses.
+// hit reports whether the lines came from the cache.
+//
+// The cache is safe to share between concurrent runs: entries are written to
+// a temporary file and renamed into place, so readers never see a partially
+// written entry.
+func cachedLines(r io.Reader, dir string, parse func(io.Reader) ([]string, error)) (lines []string, hit bool, err error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, false, err
+	}
+	sum := sha256.Sum256(data)
+	entry := filepath.Join(dir, hex.EncodeToString(sum[:])+".lines")
+
+	if cached, err := os.ReadFile(entry); err == nil {
+		if len(cached) == 0 {
+			return nil, true, nil
+		}
+		return strings.Split(strings.TrimSuffix(string(cached), "\n"), "\n"), true, nil
+	}
+
+	if lines, err = parse(bytes.NewReader(data)); err != nil {
+		return nil, false, err
+	}
+	return lines, false, writeCache(entry, lines)
+}
+
+// writeCache atomically writes a cache entry containing lines to path. Every
+// line is terminated, so that an entry holding a single blank line isn't empty
+// like an entry holding none.
+func writeCache(path string, lines []string) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var b strings.Builder
+	for _, l := range lines {
+		b.WriteString(l)
+		b.WriteByte('\n')
+	}
+	if _, err := f.WriteString(b.String()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
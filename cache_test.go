@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCachedLines(t *testing.T) {
+	dir := t.TempDir()
+	parses := 0
+	parse := func(r io.Reader) ([]string, error) {
+		parses++
+		b, err := io.ReadAll(r)
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n"), err
+	}
+
+	input := "people\nperson\n\nplace\n"
+	want := []string{"people", "person", "", "place"}
+	for i, wantHit := range []bool{false, true} {
+		lines, hit, err := cachedLines(strings.NewReader(input), dir, parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit != wantHit || parses != 1 {
+			t.Errorf("got hit %t after %d parses on read %d, want hit %t after 1 parse", hit, parses, i, wantHit)
+		}
+		if strings.Join(lines, ",") != strings.Join(want, ",") {
+			t.Errorf("got lines %q on read %d, want %q", lines, i, want)
+		}
+	}
+
+	// Input of the same size is still a different entry.
+	lines, hit, err := cachedLines(strings.NewReader("PEOPLE\nPERSON\n\nPLACE\n"), dir, parse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hit || lines[0] != "PEOPLE" {
+		t.Errorf("got hit %t and lines %q for changed input, want a miss and its new lines", hit, lines)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("got %d cache entries, want 2", len(entries))
+	}
+}
+
+func TestCachedBlankLine(t *testing.T) {
+	dir := t.TempDir()
+	parse := func(r io.Reader) ([]string, error) {
+		return []string{""}, nil
+	}
+	for _, wantHit := range []bool{false, true} {
+		lines, hit, err := cachedLines(strings.NewReader("\n"), dir, parse)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hit != wantHit || len(lines) != 1 || lines[0] != "" {
+			t.Errorf("got hit %t and lines %q, want hit %t and a single blank line", hit, lines, wantHit)
+		}
+	}
+}
+
+func TestCacheDir(t *testing.T) {
+	dir := t.TempDir()
+	input := "people\nperson\nplace\nply\ndog\n"
+	path := writeFile(t, "words.txt", input)
+	want, _, _ := runFz(t, input, "pl")
+
+	for i := 0; i < 2; i++ {
+		got, _, code := runFz(t, "", "-cache-dir", dir, "pl", path)
+		if code != 0 {
+			t.Fatalf("got exit code %d, want 0", code)
+		}
+		if got != want {
+			t.Errorf("got output %q on run %d, want %q", got, i, want)
+		}
+	}
+
+	// Stdin hashes the same as the file, so it's read from the cache too.
+	if got, _, _ := runFz(t, input, "-cache-dir", dir, "pl"); got != want {
+		t.Errorf("got output %q from stdin, want %q", got, want)
+	}
+
+	// Replacing the file's contents without changing its size or
+	// modification time, like cp -p does, isn't mistaken for a hit.
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.ToUpper(input)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	want, _, _ = runFz(t, strings.ToUpper(input), "PL")
+	if got, _, _ := runFz(t, "", "-cache-dir", dir, "PL", path); got != want {
+		t.Errorf("got output %q for a file replaced in place, want %q", got, want)
+	}
+}
@@ -0,0 +1,77 @@
+package fuzzy
+
+import "strings"
+
+// Escape is an ANSI escape sequence that was removed from an input before
+// searching it.
+type Escape struct {
+	// Pos is the offset in the stripped input that the sequence appeared
+	// before.
+	Pos int
+
+	// Seq is the full escape sequence, including the leading ESC.
+	Seq string
+}
+
+// IsSGR reports whether the escape sets graphics attributes such as color.
+func (e Escape) IsSGR() bool {
+	return strings.HasPrefix(e.Seq, "\033[") && strings.HasSuffix(e.Seq, "m")
+}
+
+// IsReset reports whether the escape resets all graphics attributes.
+func (e Escape) IsReset() bool {
+	return e.Seq == "\033[m" || e.Seq == "\033[0m"
+}
+
+// stripANSI removes ANSI escape sequences from s, returning the remaining text
+// and the removed sequences in the order they appeared.
+func stripANSI(s string) (string, []Escape) {
+	i := strings.IndexByte(s, '\033')
+	if i == -1 {
+		return s, nil
+	}
+
+	var escapes []Escape
+	plain := strings.Builder{}
+	plain.Grow(len(s))
+	for i != -1 {
+		plain.WriteString(s[:i])
+		n := escapeLen(s[i:])
+		escapes = append(escapes, Escape{Pos: plain.Len(), Seq: s[i : i+n]})
+		s = s[i+n:]
+		i = strings.IndexByte(s, '\033')
+	}
+	plain.WriteString(s)
+	return plain.String(), escapes
+}
+
+// escapeLen returns the length of the escape sequence at the start of s. An
+// unterminated sequence extends to the end of s.
+func escapeLen(s string) int {
+	if len(s) < 2 {
+		return len(s)
+	}
+	switch s[1] {
+	case '[':
+		// CSI: parameter and intermediate bytes followed by a single
+		// final byte.
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+	case ']':
+		// OSC: terminated by BEL or ST (ESC \).
+		for i := 2; i < len(s); i++ {
+			if s[i] == '\a' {
+				return i + 1
+			}
+			if s[i] == '\033' && i+1 < len(s) && s[i+1] == '\\' {
+				return i + 2
+			}
+		}
+	default:
+		return 2
+	}
+	return len(s)
+}
@@ -0,0 +1,26 @@
+package fuzzy
+
+import "testing"
+
+func TestStripANSI(t *testing.T) {
+	in := "\033[34mfoo\033[0m/\033]0;title\a\033[1;32mbar.go\033[m"
+	plain, escapes := stripANSI(in)
+	if plain != "foo/bar.go" {
+		t.Errorf("got plain text %q, want %q", plain, "foo/bar.go")
+	}
+	want := []Escape{
+		{0, "\033[34m"},
+		{3, "\033[0m"},
+		{4, "\033]0;title\a"},
+		{4, "\033[1;32m"},
+		{10, "\033[m"},
+	}
+	if len(escapes) != len(want) {
+		t.Fatalf("got %d escapes, want %d: %q", len(escapes), len(want), escapes)
+	}
+	for i := range want {
+		if escapes[i] != want[i] {
+			t.Errorf("got escape %d = %q, want %q", i, escapes[i], want[i])
+		}
+	}
+}
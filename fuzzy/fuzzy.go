@@ -0,0 +1,787 @@
+// Package fuzzy implements fz's fuzzy prefix search. It matches search terms
+// against inputs, allowing arbitrary gaps between each of the term's runes,
+// and ranks the results.
+package fuzzy
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Match returns the best result of searching for term in input, or false if
+// input doesn't contain any of the term's runes.
+func Match(input, term string) (Result, bool) {
+	return bestMatch(input, term, Options{})
+}
+
+// bestMatch returns the highest ranked result of searching for term in s, or
+// false if there were no matches.
+func bestMatch(s, term string, opts Options) (Result, bool) {
+	if !opts.FoldDiacritics {
+		return bestUnfoldedMatch(s, term, opts)
+	}
+
+	// A letter's span takes in the combining marks after it once its
+	// diacritics are folded, so the match is scored without them to rank
+	// it the same as the letters without them.
+	res, ok := bestUnfoldedMatch(s, stripMarks(term), opts)
+	if ok && res.Runes == 0 {
+		res.Runes = matchedRunes(res, opts)
+	}
+	return res, ok
+}
+
+// bestUnfoldedMatch is bestMatch without the scoring for FoldDiacritics.
+func bestUnfoldedMatch(s, term string, opts Options) (Result, bool) {
+	if opts.Substring {
+		return substringMatch(s, term, opts)
+	}
+	if opts.Ngram > 0 {
+		return ngramMatch(s, term, opts)
+	}
+	if opts.Positional {
+		return positionalMatch(s, term, opts)
+	}
+	return search(s, term, opts, 0)
+}
+
+// Spans returns the spans of the best alignment of term in input with opts, or
+// nil if it doesn't match. Span offsets are byte offsets into input.
+func Spans(term, input string, opts Options) []Span {
+	res, ok := bestMatch(input, term, opts)
+	if !ok {
+		return nil
+	}
+	return res.Matches
+}
+
+// Options configures how a term is aligned with an input.
+type Options struct {
+	// Window, when positive, rejects alignments where a matched rune is
+	// more than window bytes past the previous matched rune.
+	Window int
+
+	// Ngram, when positive, matches the term's overlapping n-grams of
+	// this many runes instead of its individual runes.
+	Ngram int
+
+	// Leet treats common leet-speak substitutions, such as 3 for e, as
+	// equivalent to the letters they replace.
+	Leet bool
+
+	// FoldCase matches runes regardless of their case.
+	FoldCase bool
+
+	// FoldDiacritics matches the precomposed letters of Latin-1 and Latin
+	// Extended-A regardless of their diacritics, so that e matches é.
+	// Other precomposed letters, such as ệ and ǎ, only match themselves.
+	// Combining marks in an input are matched along with the rune before
+	// them, and they're ignored in the term, so any decomposed letter
+	// matches its base letter.
+	FoldDiacritics bool
+
+	// PrefixRunes, when positive, requires the first prefixRunes runes of
+	// the term to match contiguously at the start of a word.
+	PrefixRunes int
+
+	// Substring only matches inputs that contain the whole term as a
+	// contiguous substring, rather than matching its runes with gaps
+	// between them.
+	Substring bool
+
+	// Positional splits the term into space-separated tokens that must
+	// each match, in order, in disjoint regions of the input.
+	Positional bool
+}
+
+// isSeparator reports whether r separates words in an input.
+func isSeparator(r rune) bool {
+	switch r {
+	case '/', '\\', '-', '_', '.', ':':
+		return true
+	}
+	return unicode.IsSpace(r)
+}
+
+// wordStart reports whether the byte offset i in s is the start of a word,
+// which is the start of s or any position after a separator.
+func wordStart(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	return isSeparator(prev)
+}
+
+// camelHump reports whether the byte offset i in s is at an uppercase rune
+// immediately following a lowercase one, like the R in fooReader.
+func camelHump(s string, i int) bool {
+	if i == 0 || i >= len(s) {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(s[i:])
+	prev, _ := utf8.DecodeLastRuneInString(s[:i])
+	return unicode.IsUpper(r) && unicode.IsLower(prev)
+}
+
+// anchoredPrefix reports whether the first k runes of the term matched
+// contiguously at the start of a word in the result's input.
+func anchoredPrefix(r Result, k int) bool {
+	first := r.Matches[0]
+	if utf8.RuneCountInString(r.Input[first.Start:first.End]) < k {
+		return false
+	}
+	return wordStart(r.Input, first.Start)
+}
+
+// leetFold maps runes that are commonly substituted for each other in
+// leet-speak to a single representative rune.
+func leetFold(r rune) rune {
+	switch r {
+	case '0':
+		return 'o'
+	case '1', 'l':
+		return 'i'
+	case '3':
+		return 'e'
+	case '@':
+		return 'a'
+	case '$':
+		return 's'
+	}
+	return r
+}
+
+// diacritics maps the precomposed letters of Latin-1 and Latin Extended-A to
+// the letters they're based on.
+var diacritics = func() map[rune]rune {
+	m := make(map[rune]rune)
+	for base, letters := range map[rune]string{
+		'A': "ÀÁÂÃÄÅĀĂĄ", 'a': "àáâãäåāăą",
+		'C': "ÇĆĈĊČ", 'c': "çćĉċč",
+		'D': "ĎĐ", 'd': "ďđ",
+		'E': "ÈÉÊËĒĔĖĘĚ", 'e': "èéêëēĕėęě",
+		'G': "ĜĞĠĢ", 'g': "ĝğġģ",
+		'H': "ĤĦ", 'h': "ĥħ",
+		'I': "ÌÍÎÏĨĪĬĮİ", 'i': "ìíîïĩīĭįı",
+		'J': "Ĵ", 'j': "ĵ",
+		'K': "Ķ", 'k': "ķ",
+		'L': "ĹĻĽĿŁ", 'l': "ĺļľŀł",
+		'N': "ÑŃŅŇ", 'n': "ñńņň",
+		'O': "ÒÓÔÕÖØŌŎŐ", 'o': "òóôõöøōŏő",
+		'R': "ŔŖŘ", 'r': "ŕŗř",
+		'S': "ŚŜŞŠ", 's': "śŝşš",
+		'T': "ŢŤŦ", 't': "ţťŧ",
+		'U': "ÙÚÛÜŨŪŬŮŰŲ", 'u': "ùúûüũūŭůűų",
+		'W': "Ŵ", 'w': "ŵ",
+		'Y': "ÝŶŸ", 'y': "ýÿŷ",
+		'Z': "ŹŻŽ", 'z': "źżž",
+	} {
+		for _, r := range letters {
+			m[r] = base
+		}
+	}
+	return m
+}()
+
+// isMark reports whether r is a combining mark, such as the acute accent that
+// follows the e in a decomposed é.
+func isMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r)
+}
+
+// stripMarks returns s without any combining marks.
+func stripMarks(s string) string {
+	if strings.IndexFunc(s, isMark) == -1 {
+		return s
+	}
+	return strings.Map(func(r rune) rune {
+		if isMark(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// matchedRunes returns the number of runes in the result's spans, not counting
+// combining marks when they're folded.
+func matchedRunes(r Result, opts Options) int {
+	n := 0
+	for _, m := range r.Matches {
+		for _, c := range r.Input[m.Start:m.End] {
+			if !opts.FoldDiacritics || !isMark(c) {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// fold maps r to the rune that represents every rune matching it under the
+// options.
+func (opts Options) fold(r rune) rune {
+	if opts.FoldDiacritics {
+		if base, ok := diacritics[r]; ok {
+			r = base
+		}
+	}
+	if opts.FoldCase {
+		r = foldCase(r)
+	}
+	if opts.Leet {
+		r = leetFold(r)
+	}
+	return r
+}
+
+// foldCase maps r to a single rune for all of the runes that are equivalent to
+// it under Unicode simple case folding. Lowercasing alone isn't enough, since
+// runes like ς and σ are both lowercase forms of Σ. The rune is the lowercase
+// form of the smallest rune in r's folding orbit, so ASCII letters fold to the
+// same runes that ToLower gives.
+func foldCase(r rune) rune {
+	if r < utf8.RuneSelf {
+		return unicode.ToLower(r)
+	}
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return unicode.ToLower(min)
+}
+
+// indexRune returns the index of the first rune in s that matches r, or -1 if
+// there isn't one.
+func indexRune(s string, r rune, opts Options) int {
+	if !opts.Leet && !opts.FoldCase && !opts.FoldDiacritics {
+		return strings.IndexRune(s, r)
+	}
+	r = opts.fold(r)
+	return strings.IndexFunc(s, func(c rune) bool { return opts.fold(c) == r })
+}
+
+// regexpMatch matches re against s. The result's spans are the non-empty
+// matches of re, so its match score is their total length. Inputs where re
+// only matches an empty string don't match.
+func regexpMatch(s string, re *regexp.Regexp) (Result, bool) {
+	res := Result{Input: s}
+	for _, loc := range re.FindAllStringIndex(s, -1) {
+		if loc[1] > loc[0] {
+			res.Matches = append(res.Matches, Span{Start: loc[0], End: loc[1]})
+		}
+	}
+	if len(res.Matches) == 0 {
+		return Result{}, false
+	}
+	res.Alignments = len(res.Matches)
+	return res, true
+}
+
+// substringMatch finds the whole of term as a contiguous substring of s,
+// preferring an occurrence at a word boundary over an earlier one. With
+// PrefixRunes, only occurrences at the start of a word are accepted, since the
+// whole term is the occurrence's first span. The result's alignments is the
+// number of accepted occurrences.
+func substringMatch(s, term string, opts Options) (Result, bool) {
+	if term == "" {
+		return Result{}, false
+	}
+	res := Result{Input: s}
+	boundary := func(i int) bool { return wordStart(s, i) || camelHump(s, i) }
+	best := Span{Start: -1}
+	for i := range s {
+		if opts.PrefixRunes > 0 && !wordStart(s, i) {
+			continue
+		}
+		n, ok := foldedPrefix(s[i:], term, opts)
+		if !ok {
+			continue
+		}
+		res.Alignments++
+		if best.Start == -1 || !boundary(best.Start) && boundary(i) {
+			best = Span{Start: i, End: i + n}
+		}
+	}
+	if best.Start == -1 {
+		return Result{}, false
+	}
+	res.Matches = []Span{best}
+	return res, true
+}
+
+// indexFolded returns the index of the first substring of s that matches sub
+// rune for rune, and its length in bytes, or -1 if there isn't one.
+func indexFolded(s, sub string, opts Options) (int, int) {
+	if !opts.Leet && !opts.FoldCase && !opts.FoldDiacritics {
+		return strings.Index(s, sub), len(sub)
+	}
+	for i := range s {
+		if n, ok := foldedPrefix(s[i:], sub, opts); ok {
+			return i, n
+		}
+	}
+	return -1, 0
+}
+
+// foldedPrefix returns the length in bytes of the prefix of s that matches
+// term rune for rune, or false if s doesn't start with term.
+func foldedPrefix(s, term string, opts Options) (int, bool) {
+	n := 0
+	for _, t := range term {
+		r, size := utf8.DecodeRuneInString(s[n:])
+		if size == 0 || opts.fold(r) != opts.fold(t) {
+			return 0, false
+		}
+		n += size
+		for opts.FoldDiacritics {
+			r, size := utf8.DecodeRuneInString(s[n:])
+			if size == 0 || !isMark(r) {
+				break
+			}
+			n += size
+		}
+	}
+	return n, true
+}
+
+// positionalMatch matches each space-separated token of term against s in
+// order. Each token's match must start after the previous token's match ends,
+// and every token must match. The result contains the spans of all tokens.
+func positionalMatch(s, term string, opts Options) (Result, bool) {
+	opts.Positional = false
+	res := Result{Input: s, Alignments: 1}
+	offset := 0
+	for _, token := range strings.Fields(term) {
+		best, ok := search(s, token, opts, offset)
+		if !ok {
+			return Result{}, false
+		}
+		res.Matches = append(res.Matches, best.Matches...)
+		offset = best.Matches[len(best.Matches)-1].End
+	}
+	if len(res.Matches) == 0 {
+		return Result{}, false
+	}
+	return res, true
+}
+
+// andMatch matches each of terms against s independently, and only matches if
+// every term does. The result's match score is the sum of the terms' match
+// scores, and its spans are all of the terms' spans with any overlaps merged.
+func andMatch(s string, terms []string, opts Options) (Result, bool) {
+	res := Result{Input: s, Alignments: 1}
+	var all []Span
+	for _, term := range terms {
+		r, ok := bestMatch(s, term, opts)
+		if !ok {
+			return Result{}, false
+		}
+		all = append(all, r.Matches...)
+		res.Runes += r.MatchScore()
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Start < all[j].Start })
+	for _, m := range all {
+		if n := len(res.Matches); n > 0 && m.Start < res.Matches[n-1].End {
+			if m.End > res.Matches[n-1].End {
+				res.Matches[n-1].End = m.End
+			}
+			continue
+		}
+		res.Matches = append(res.Matches, m)
+	}
+	return res, true
+}
+
+// ngramMatch searches s for the overlapping n-grams of k runes in term, in
+// order. Grams that can't be found are skipped rather than ending the match,
+// which makes it more tolerant of typos than search. To keep scores comparable
+// with search, the result's match score is the number of term runes covered by
+// a matched gram, so a full match scores the same in both. Grams are k runes
+// long, where k is opts.Ngram, and they match runes the way opts folds them.
+func ngramMatch(s, term string, opts Options) (Result, bool) {
+	k := opts.Ngram
+	termRunes := []rune(term)
+	if len(termRunes) < k {
+		k = len(termRunes)
+	}
+	covered := make([]bool, len(termRunes))
+	res := Result{Input: s}
+	offset := 0
+	for i := 0; k > 0 && i+k <= len(termRunes); i++ {
+		gram := string(termRunes[i : i+k])
+		j, n := indexFolded(s[offset:], gram, opts)
+		if j == -1 {
+			continue
+		}
+		for c := i; c < i+k; c++ {
+			covered[c] = true
+		}
+
+		// Grams overlap, so merge any that touch the previous span.
+		start, end := offset+j, offset+j+n
+		if n := len(res.Matches); n > 0 && start <= res.Matches[n-1].End {
+			if end > res.Matches[n-1].End {
+				res.Matches[n-1].End = end
+			}
+		} else {
+			res.Matches = append(res.Matches, Span{Start: start, End: end})
+		}
+
+		// The next gram must start after this one does.
+		_, size := utf8.DecodeRuneInString(s[start:])
+		offset = start + size
+	}
+
+	for _, c := range covered {
+		if c {
+			res.Runes++
+		}
+	}
+	if res.Runes == 0 {
+		return Result{}, false
+	}
+	res.Alignments = 1
+	return res, true
+}
+
+// alignScore scores part of an alignment of a term with an input: the term
+// runes it matched, the spans it matched them in, and how many of those
+// spans start at a word boundary. Scores add up, and they compare in the same
+// order that ByRank compares results.
+type alignScore struct {
+	matched, spans, bounds int32
+}
+
+func (a alignScore) add(b alignScore) alignScore {
+	return alignScore{a.matched + b.matched, a.spans + b.spans, a.bounds + b.bounds}
+}
+
+func (a alignScore) better(b alignScore) bool {
+	if a.matched != b.matched {
+		return a.matched > b.matched
+	}
+	if a.spans != b.spans {
+		return a.spans < b.spans
+	}
+	return a.bounds > b.bounds
+}
+
+// alignStep is the best way to finish an alignment after matching a term rune
+// with an input rune. next is the input rune that the next term rune matches,
+// or -1 if the alignment ends.
+type alignStep struct {
+	score alignScore
+	next  int32
+	ok    bool
+}
+
+// maxSearchWork is the most pairs of term and input runes that search compares
+// for a single input. Inputs that would take more, like a very long line of
+// the term's first rune repeated, are aligned greedily instead, so that one
+// input can't stall a search or use too much memory.
+const maxSearchWork = 1 << 20
+
+// search finds the best alignment of term with the part of s after offset, as
+// ranked by ByRank. An alignment matches a prefix of the term's runes, in
+// order, with runes of s. It only ends before the whole term is matched if the
+// next term rune doesn't appear later in s, and every alignment must satisfy
+// the window and prefix constraints in opts. The result's alignments is the
+// number of runes in s that an acceptable alignment can start at.
+//
+// Every alignment is considered using dynamic programming over pairs of term
+// and input runes, which takes O(len(s) * len(term)) time, or that times the
+// window when there is one. When that's more than maxSearchWork, the greedy
+// alignment found by align is returned instead.
+func search(s, term string, opts Options, offset int) (Result, bool) {
+	t, ok := tabulate(s, term, opts, offset)
+	if !ok {
+		return Result{}, false
+	}
+	if t == nil {
+		return greedy(s, term, opts, offset)
+	}
+
+	best, bestStart, alignments := alignScore{}, -1, 0
+	for i := 0; i < t.n; i++ {
+		if !t.accepts(i) {
+			continue
+		}
+		alignments++
+		score := t.steps[i].score.add(t.spanStart[i])
+		if bestStart == -1 || score.better(best) || !best.better(score) && t.starts[i] == 0 {
+			best, bestStart = score, i
+		}
+	}
+	if bestStart == -1 {
+		return Result{}, false
+	}
+	res := t.trace(bestStart)
+	res.Alignments = alignments
+	return res, true
+}
+
+// Matches returns the best alignment of term in input that starts at each rune
+// of input where one can, in rank order, so that the alternatives to the best
+// result from Match can be inspected. Each result has one alignment. Inputs too
+// long to search fully only have their greedy alignment returned.
+func Matches(input, term string) []Result {
+	t, ok := tabulate(input, term, Options{}, 0)
+	if !ok {
+		return nil
+	}
+	if t == nil {
+		if r, ok := greedy(input, term, Options{}, 0); ok {
+			return []Result{r}
+		}
+		return nil
+	}
+	var results []Result
+	for i := 0; i < t.n; i++ {
+		if t.accepts(i) {
+			r := t.trace(i)
+			r.Alignments = 1
+			results = append(results, r)
+		}
+	}
+	sort.Sort(ByRank(results))
+	return results
+}
+
+// greedy returns the greedy alignment of term with the part of s after
+// offset, for inputs that take too much work to search.
+func greedy(s, term string, opts Options, offset int) (Result, bool) {
+	res, ok := align(s, term, opts, offset)
+	if !ok || len(res.Matches) == 0 {
+		return Result{}, false
+	}
+	res.Alignments = 1
+	return res, true
+}
+
+// alignTable holds the best way to finish an alignment after each pair of a
+// term rune and an input rune, as found by search.
+type alignTable struct {
+	s string
+	n int
+
+	// starts holds the byte offset in s of each input rune, followed by
+	// the length of s.
+	starts []int
+
+	// spanStart[i] is the score of starting a span at input rune i,
+	// which includes a word boundary if it's at one.
+	spanStart []alignScore
+
+	// steps[j*n+i] is the best way to finish an alignment that matched
+	// term rune j with input rune i.
+	steps []alignStep
+
+	// anchored is whether alignments have to start at a word.
+	anchored bool
+}
+
+// accepts reports whether an alignment can start at input rune i.
+func (t *alignTable) accepts(i int) bool {
+	return t.steps[i].ok && (!t.anchored || wordStart(t.s, t.starts[i]))
+}
+
+// trace returns the result of the alignment that starts at input rune start.
+func (t *alignTable) trace(start int) Result {
+	res := Result{Input: t.s}
+	for i, j := start, 0; i != -1; j++ {
+		if l := len(res.Matches); l > 0 && res.Matches[l-1].End == t.starts[i] {
+			res.Matches[l-1].End = t.starts[i+1]
+		} else {
+			res.Matches = append(res.Matches, Span{Start: t.starts[i], End: t.starts[i+1]})
+		}
+		i = int(t.steps[j*t.n+i].next)
+	}
+	return res
+}
+
+// tabulate fills in the table of alignments of term with the part of s after
+// offset. It returns false if the term's first rune isn't there, and a nil
+// table if filling it in would take more than maxSearchWork.
+func tabulate(s, term string, opts Options, offset int) (*alignTable, bool) {
+	termRunes := []rune(term)
+	if len(termRunes) == 0 || indexRune(s[offset:], termRunes[0], opts) == -1 {
+		return nil, false
+	}
+	for j, r := range termRunes {
+		termRunes[j] = opts.fold(r)
+	}
+	var runes []rune
+	var starts []int
+	for i, c := range s[offset:] {
+		// A combining mark is matched along with the rune before it,
+		// since that rune's span ends at the next rune's start.
+		if opts.FoldDiacritics && isMark(c) && len(runes) > 0 {
+			continue
+		}
+		runes = append(runes, opts.fold(c))
+		starts = append(starts, offset+i)
+	}
+	n, m := len(runes), len(termRunes)
+	starts = append(starts, len(s))
+	work := n * m
+	if w := opts.Window; w > 0 {
+		if w > n {
+			w = n
+		}
+		work *= w
+	}
+	if work > maxSearchWork {
+		return nil, true
+	}
+
+	// spanStart[i] is the score of starting a span at input rune i, which
+	// includes a word boundary if it's at one.
+	spanStart := make([]alignScore, n)
+	for i := range spanStart {
+		spanStart[i].spans = 1
+		if wordStart(s, starts[i]) || camelHump(s, starts[i]) {
+			spanStart[i].bounds = 1
+		}
+	}
+
+	k := opts.PrefixRunes
+	if k > m {
+		k = m
+	}
+
+	// last[j] is the last input rune that term rune j matches, so an
+	// alignment can only end after term rune j-1 once it's passed.
+	last := make([]int, m)
+	for j := range last {
+		last[j] = -1
+		for i := n - 1; i >= 0; i-- {
+			if runes[i] == termRunes[j] {
+				last[j] = i
+				break
+			}
+		}
+	}
+
+	// steps[j*n+i] is the best way to finish an alignment that matched
+	// term rune j with input rune i. Rows are filled from the end of the
+	// term backwards. jumps holds the best step in the previous row that
+	// starts a new span at or after each input rune, for alignments
+	// without a window.
+	steps := make([]alignStep, m*n)
+	jumps := make([]int32, n+1)
+	nextJumps := make([]int32, n+1)
+	for j := m - 1; j >= 0; j-- {
+		row := steps[j*n : (j+1)*n]
+		var below []alignStep
+		if j+1 < m {
+			below = steps[(j+1)*n : (j+2)*n]
+		}
+		for i := n - 1; i >= 0; i-- {
+			if runes[i] != termRunes[j] {
+				continue
+			}
+			var best alignStep
+			try := func(next int, score alignScore) {
+				if !best.ok || score.better(best.score) {
+					best = alignStep{score: score, next: int32(next), ok: true}
+				}
+			}
+
+			// The first k term runes have to match contiguously,
+			// so the alignment can't end or jump within them.
+			free := j+1 >= k
+			if free && (j+1 == m || last[j+1] <= i) {
+				try(-1, alignScore{})
+			}
+			if below != nil {
+				if i+1 < n && below[i+1].ok {
+					try(i+1, below[i+1].score)
+				}
+				switch {
+				case !free:
+				case opts.Window > 0:
+					for i2 := i + 2; i2 < n && starts[i2]-starts[i+1] <= opts.Window; i2++ {
+						if below[i2].ok {
+							try(i2, below[i2].score.add(spanStart[i2]))
+						}
+					}
+				case i+2 < n && jumps[i+2] != -1:
+					i2 := int(jumps[i+2])
+					try(i2, below[i2].score.add(spanStart[i2]))
+				}
+			}
+			if best.ok {
+				best.score.matched++
+				row[i] = best
+			}
+		}
+
+		nextJumps[n] = -1
+		for i := n - 1; i >= 0; i-- {
+			nextJumps[i] = nextJumps[i+1]
+			if !row[i].ok {
+				continue
+			}
+			if b := nextJumps[i]; b == -1 || !row[b].score.add(spanStart[b]).better(row[i].score.add(spanStart[i])) {
+				nextJumps[i] = int32(i)
+			}
+		}
+		jumps, nextJumps = nextJumps, jumps
+	}
+
+	return &alignTable{s: s, n: n, starts: starts, spanStart: spanStart, steps: steps, anchored: k > 0}, true
+}
+
+// align greedily aligns term with the part of s after offset, matching each
+// rune of the term with the first matching rune after the previous match. The
+// alignment ends at the first term rune that can't be found. ok is false if the
+// alignment breaks the window or prefix constraints in opts.
+func align(s, term string, opts Options, offset int) (res Result, ok bool) {
+	// Only search the part of the input after the offset.
+	tail := s[offset:]
+	res = Result{Input: s}
+	for _, r := range term {
+		i := indexRune(tail, r, opts)
+		if i == -1 {
+			break
+		}
+		if opts.Window > 0 && i > opts.Window && len(res.Matches) > 0 {
+			return res, false
+		}
+
+		// Check if there was a gap between the previous rune match and
+		// this rune match. If we didn't advance, then there's no gap
+		// and we extend the last span. Otherwise, start a new span
+		// at the current position. Spans are byte offsets, so they're
+		// extended by the width of the matched rune in the input.
+		_, size := utf8.DecodeRuneInString(tail[i:])
+		if i == 0 && len(res.Matches) > 0 {
+			res.Matches[len(res.Matches)-1].End += size
+		} else {
+			res.Matches = append(res.Matches, Span{
+				Start: offset + i,
+				End:   offset + i + size,
+			})
+		}
+
+		i += size
+		tail = tail[i:]
+		offset += i
+	}
+
+	if opts.PrefixRunes > 0 && len(res.Matches) > 0 {
+		k := opts.PrefixRunes
+		if n := utf8.RuneCountInString(term); n < k {
+			k = n
+		}
+		if !anchoredPrefix(res, k) {
+			return res, false
+		}
+	}
+	return res, true
+}
@@ -0,0 +1,326 @@
+package fuzzy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gcurtis/fz/internal/corpus"
+)
+
+func TestSpans(t *testing.T) {
+	got := Spans("CAT", "CxxxAxxxTCAT", Options{})
+	want := []Span{{Start: 9, End: 12}}
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("got spans %v, want %v", got, want)
+	}
+
+	if got := Spans("CAT", "dog", Options{}); got != nil {
+		t.Errorf("got spans %v for a non-matching input, want none", got)
+	}
+
+	if got := Spans("cat", "xCAT", Options{}); got != nil {
+		t.Errorf("got spans %v without FoldCase, want none", got)
+	}
+	got = Spans("cat", "xCAT", Options{FoldCase: true})
+	if want := (Span{Start: 1, End: 4}); len(got) != 1 || got[0] != want {
+		t.Errorf("got spans %v with FoldCase, want [%v]", got, want)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, tt := range []struct {
+		input, term string
+		want        string
+		alignments  int
+	}{
+		{"CxxxAxxxTCAT", "CAT", "[{9 12}]", 2},
+		{"axbcxbcd", "abcd", "[{0 1} {5 8}]", 1},
+		{"people", "pl", "[{3 5}]", 2},
+		{"plx", "plo", "[{0 2}]", 1},
+		{"日本語のテキスト", "本ト", "[{3 6} {21 24}]", 1},
+	} {
+		r, ok := bestMatch(tt.input, tt.term, Options{})
+		if !ok || fmt.Sprint(r.Matches) != tt.want || r.Alignments != tt.alignments {
+			t.Errorf("bestMatch(%q, %q) = %v with %d alignments, want %s with %d", tt.input, tt.term, r.Matches, r.Alignments, tt.want, tt.alignments)
+		}
+	}
+
+	// Long inputs full of alignments are matched in linear time.
+	input := strings.Repeat("a", 10000)
+	if r, _ := bestMatch(input+"b", "aab", Options{}); fmt.Sprint(r.Matches) != "[{9998 10001}]" {
+		t.Errorf("got %v for a long input, want the match at its end", r.Matches)
+	}
+	if r, _ := bestMatch(input, "aaa", Options{}); fmt.Sprint(r.Matches) != "[{0 3}]" || r.Alignments != 10000 {
+		t.Errorf("got %v with %d alignments for a repeated rune, want [{0 3}] with 10000", r.Matches, r.Alignments)
+	}
+}
+
+func TestSearchWindow(t *testing.T) {
+	opts := Options{Window: 3}
+	if _, ok := bestMatch("xaxxbc", "abc", opts); !ok {
+		t.Errorf("got no match for a tightly ordered input")
+	}
+
+	if res, ok := bestMatch("axxxxxbc", "abc", opts); ok {
+		t.Errorf("got match %v for an input that jumps past the window", res.Matches)
+	}
+	if _, ok := bestMatch("axxxxxbc", "abc", Options{}); !ok {
+		t.Errorf("got no match without a window")
+	}
+}
+
+func TestNgram(t *testing.T) {
+	res, ok := ngramMatch("people", "people", Options{Ngram: 3})
+	if !ok || res.MatchScore() != 6 || len(res.Matches) != 1 {
+		t.Errorf("got n-gram match %v with score %d, want a single span scoring 6", res.Matches, res.MatchScore())
+	}
+	if _, ok := ngramMatch("dog", "people", Options{Ngram: 2}); ok {
+		t.Error("got an n-gram match for an input sharing no grams")
+	}
+
+	if _, ok := ngramMatch("HELLO", "hello", Options{Ngram: 2}); ok {
+		t.Error("got an n-gram match in a different case without FoldCase")
+	}
+	res, ok = ngramMatch("HELLO", "hello", Options{Ngram: 2, FoldCase: true})
+	if !ok || res.MatchScore() != 5 || fmt.Sprint(res.Matches) != "[{0 5}]" {
+		t.Errorf("got n-gram match %v with score %d and FoldCase, want all of HELLO", res.Matches, res.MatchScore())
+	}
+	res, ok = ngramMatch("h3ll0", "hello", Options{Ngram: 2, Leet: true})
+	if !ok || res.MatchScore() != 5 {
+		t.Errorf("got n-gram match %v with score %d and Leet, want all of h3ll0", res.Matches, res.MatchScore())
+	}
+}
+
+func TestAndTerms(t *testing.T) {
+	// Overlapping spans are merged, but still count towards each term.
+	r, ok := andMatch("main.go", []string{"ma", "ain"}, Options{})
+	if !ok || r.MatchScore() != 5 || fmt.Sprint(r.Matches) != "[{0 4}]" {
+		t.Errorf("got %v, %v with score %d, want a single span scoring 5", r.Matches, ok, r.MatchScore())
+	}
+
+	s := New("src main")
+	if _, ok := s.Match("main/other.go"); ok {
+		t.Error("got a match for an input missing one of the terms")
+	}
+	if r, ok := s.Match("src/main.go"); !ok || fmt.Sprint(r.Matches) != "[{0 3} {4 8}]" {
+		t.Errorf("got %v, %v, want both terms matched", r.Matches, ok)
+	}
+}
+
+func TestWordBoundary(t *testing.T) {
+	r, _ := bestMatch("xmax_max", "ma", Options{})
+	if want := "[{5 7}]"; fmt.Sprint(r.Matches) != want {
+		t.Errorf("got best alignment %v, want %s at a word boundary", r.Matches, want)
+	}
+}
+
+func TestCamelHump(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		i    int
+		want bool
+	}{
+		{"fooReader", 3, true},
+		{"fooReader", 0, false},
+		{"FOOReader", 3, false},
+		{"foo_Reader", 4, false},
+		{"éÉ", 2, true},
+	} {
+		if got := camelHump(tt.s, tt.i); got != tt.want {
+			t.Errorf("camelHump(%q, %d) = %v, want %v", tt.s, tt.i, got, tt.want)
+		}
+	}
+}
+
+func TestBasenameBonus(t *testing.T) {
+	r, _ := bestMatch("ab/cd", "bc", Options{})
+	if got := basenameBonus(r); got != 0.25 {
+		t.Errorf("got bonus %v for a match split across the basename, want 0.25", got)
+	}
+}
+
+func TestSubstring(t *testing.T) {
+	opts := Options{Substring: true}
+	for _, tt := range []struct {
+		input, want string
+		alignments  int
+	}{
+		{"concatenate", "[{3 6}]", 1},
+		{"c-a-t.txt", "[]", 0},
+		{"xcat-cat", "[{5 8}]", 2},
+		{"catalog", "[{0 3}]", 1},
+	} {
+		r, _ := bestMatch(tt.input, "cat", opts)
+		if fmt.Sprint(r.Matches) != tt.want || r.Alignments != tt.alignments {
+			t.Errorf("got %v with %d alignments for %q, want %s with %d", r.Matches, r.Alignments, tt.input, tt.want, tt.alignments)
+		}
+	}
+	opts.FoldCase = true
+	if r, ok := bestMatch("xxCatalogCat", "cat", opts); !ok || fmt.Sprint(r.Matches) != "[{2 5}]" {
+		t.Errorf("got %v, %v folding case, want the first occurrence at a boundary", r.Matches, ok)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	got := Matches("CxxxAxxxTCAT", "CAT")
+	var spans []string
+	for _, r := range got {
+		spans = append(spans, fmt.Sprint(r.Matches))
+	}
+	// The alignment from the first C can jump to the final A and T, which
+	// is better than matching the others with gaps between each rune.
+	if want := []string{"[{9 12}]", "[{0 1} {10 12}]"}; strings.Join(spans, " ") != strings.Join(want, " ") {
+		t.Errorf("got alternatives %v, want %v", spans, want)
+	}
+	if best, _ := Match("CxxxAxxxTCAT", "CAT"); fmt.Sprint(best.Matches) != spans[0] {
+		t.Errorf("got best alternative %v, want Match's %v", spans[0], best.Matches)
+	}
+	if got := Matches("dog", "cat"); got != nil {
+		t.Errorf("got alternatives %v without a match, want none", got)
+	}
+}
+
+func TestFoldCase(t *testing.T) {
+	opts := Options{FoldCase: true}
+	for _, tt := range []struct {
+		input, term string
+	}{
+		{"ΟΔΥΣΣΕΥΣ", "οδυσσευς"},
+		{"οδυσσεύς", "ΟΔΥΣΣ"},
+		{"STRAẞE", "straße"},
+		{"\u212aelvin", "kelvin"},
+		{"ſtring", "STRING"},
+	} {
+		if r, ok := bestMatch(tt.input, tt.term, opts); !ok || matchedRunes(r, opts) != len([]rune(tt.term)) {
+			t.Errorf("got %v, %v for %q in %q, want every rune to match", r.Matches, ok, tt.term, tt.input)
+		}
+	}
+	if r, ok := bestMatch("ΟΔΥΣΣΕΥΣ", "οδυσσευς", Options{}); ok && matchedRunes(r, Options{}) == 8 {
+		t.Errorf("got a full match %v without folding case", r.Matches)
+	}
+}
+
+func TestPositional(t *testing.T) {
+	opts := Options{Positional: true}
+
+	res, ok := bestMatch("src/cmd/main.go", "src main", opts)
+	if !ok {
+		t.Fatal("got no match for tokens in order")
+	}
+	want := []Span{{0, 3}, {8, 12}}
+	if fmt.Sprint(res.Matches) != fmt.Sprint(want) {
+		t.Errorf("got spans %v, want %v", res.Matches, want)
+	}
+
+	if res, ok := bestMatch("main/src.go", "src main", opts); ok {
+		t.Errorf("got match %v for tokens out of order", res.Matches)
+	}
+
+	// Each token has to match after the previous one ends, so they can't
+	// share the "b".
+	if res, ok := bestMatch("abc", "ab bc", opts); ok {
+		t.Errorf("got match %v for tokens in overlapping regions", res.Matches)
+	}
+	if _, ok := bestMatch("abxbc", "ab bc", opts); !ok {
+		t.Error("got no match for tokens in disjoint regions")
+	}
+}
+
+func TestPrefixRunes(t *testing.T) {
+	opts := Options{PrefixRunes: 2}
+	for _, tt := range []struct {
+		input string
+		match bool
+	}{
+		{"config", true},
+		{"my-config.go", true},
+		{"src/cofig", true},
+		{"xconfig", false},
+		{"c-o-n-f-i-g", false},
+		{"cxonfig", false},
+		{"cxonfig co", true},
+	} {
+		if _, ok := bestMatch(tt.input, "cofig", opts); ok != tt.match {
+			t.Errorf("got match %v for %q, want %v", ok, tt.input, tt.match)
+		}
+	}
+
+	// A term shorter than the prefix only needs to match contiguously.
+	if _, ok := bestMatch("a-bc", "b", opts); !ok {
+		t.Error("got no match for a term shorter than the prefix")
+	}
+}
+
+func TestMultibyte(t *testing.T) {
+	res, ok := Match("café-menu.txt", "café")
+	if !ok {
+		t.Fatal("got no match")
+	}
+	want := []Span{{0, len("café")}}
+	if fmt.Sprint(res.Matches) != fmt.Sprint(want) {
+		t.Errorf("got spans %v, want %v", res.Matches, want)
+	}
+}
+
+// benchmarkLongLine measures matching a single pathological input of m bytes,
+// where every alignment of the term has to be considered.
+func benchmarkLongLine(b *testing.B, m int) {
+	input := corpus.Pathological(1, m)[0]
+	for i := 0; i < b.N; i++ {
+		bestMatch(input, "moo", Options{})
+	}
+}
+
+func BenchmarkRepeatedRune(b *testing.B) {
+	input := strings.Repeat("a", 10000)
+	for i := 0; i < b.N; i++ {
+		bestMatch(input, "aaa", Options{})
+	}
+}
+
+func BenchmarkLongLine1000(b *testing.B)  { benchmarkLongLine(b, 1000) }
+func BenchmarkLongLine10000(b *testing.B) { benchmarkLongLine(b, 10000) }
+func BenchmarkLongLine50000(b *testing.B) { benchmarkLongLine(b, 50000) }
+
+func TestFoldDiacritics(t *testing.T) {
+	opts := Options{FoldDiacritics: true}
+	for _, tt := range []struct {
+		input, term, want string
+		score             int
+	}{
+		{"résumé", "resume", "[{0 8}]", 6},
+		{"re\u0301sume\u0301", "resume", "[{0 10}]", 6},
+		{"resume", "re\u0301sumé", "[{0 6}]", 6},
+		{"xrés", "res", "[{1 5}]", 3},
+	} {
+		r, ok := bestMatch(tt.input, tt.term, opts)
+		if !ok || fmt.Sprint(r.Matches) != tt.want || r.MatchScore() != tt.score {
+			t.Errorf("got %v scoring %d for %q in %q, want %s scoring %d", r.Matches, r.MatchScore(), tt.term, tt.input, tt.want, tt.score)
+		}
+	}
+
+	opts.Substring = true
+	if r, ok := bestMatch("cafe\u0301", "cafe", opts); !ok || fmt.Sprint(r.Matches) != "[{0 6}]" {
+		t.Errorf("got %v, %v for a substring, want the combining mark matched too", r.Matches, ok)
+	}
+}
+
+func TestSearchWork(t *testing.T) {
+	input := strings.Repeat("a", 100000)
+	term := strings.Repeat("a", 500) + "b"
+	start := time.Now()
+	r, ok := bestMatch(input, term, Options{})
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("got a match after %v, want it to give up early", elapsed)
+	}
+	if !ok || r.MatchScore() != 500 {
+		t.Errorf("got %v, %v with a match score of %d, want the greedy match of every a", r.Matches, ok, r.MatchScore())
+	}
+
+	// Inputs within the budget are still searched fully.
+	if r, _ := bestMatch("a-xb-ab", "ab", Options{}); fmt.Sprint(r.Matches) != "[{5 7}]" {
+		t.Errorf("got spans %v, want the contiguous match", r.Matches)
+	}
+}
@@ -0,0 +1,358 @@
+package fuzzy
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ByRank sorts results by their score, then gap score, then boundary score,
+// then their longest span, then the size of their gaps, then whether they match
+// at the end, then whether they match at the start, then how early their first
+// match starts, then shortest length, and finally by their inputs in
+// lexicographic order so that the ranking is deterministic.
+type ByRank []Result
+
+func (r ByRank) Len() int {
+	return len(r)
+}
+
+func (r ByRank) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r ByRank) Less(i, j int) bool {
+	return rankLess(&r[i], &r[j], false)
+}
+
+// ByDepth sorts results like ByRank, except that results that tie before their
+// lengths are compared are sorted by the number of slashes in their inputs
+// first, so that shallower paths rank above deeper ones.
+type ByDepth []Result
+
+func (r ByDepth) Len() int {
+	return len(r)
+}
+
+func (r ByDepth) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r ByDepth) Less(i, j int) bool {
+	return rankLess(&r[i], &r[j], true)
+}
+
+// rankLess reports whether a ranks above b, breaking ties by the depth of their
+// paths before their lengths when depth is set.
+func rankLess(a, b *Result, depth bool) bool {
+	if a.Score() != b.Score() {
+		return a.Score() > b.Score()
+	}
+	if a.GapScore() != b.GapScore() {
+		return a.GapScore() > b.GapScore()
+	}
+	if a.BoundaryScore() != b.BoundaryScore() {
+		return a.BoundaryScore() > b.BoundaryScore()
+	}
+	if a.LongestSpan() != b.LongestSpan() {
+		return a.LongestSpan() > b.LongestSpan()
+	}
+	if a.GapSize() != b.GapSize() {
+		return a.GapSize() < b.GapSize()
+	}
+	if a.AtEnd() != b.AtEnd() {
+		return a.AtEnd()
+	}
+	if a.AtStart() != b.AtStart() {
+		return a.AtStart()
+	}
+	if a.FirstStart() != b.FirstStart() {
+		return a.FirstStart() < b.FirstStart()
+	}
+	if depth {
+		if da, db := strings.Count(a.Input, "/"), strings.Count(b.Input, "/"); da != db {
+			return da < db
+		}
+	}
+	if len(a.Input) != len(b.Input) {
+		return len(a.Input) < len(b.Input)
+	}
+	return a.Input < b.Input
+}
+
+// Scorer ranks results for a Searcher in place of ByRank, for orders that
+// depend on more than how well inputs match, like how recently they were used.
+type Scorer interface {
+	// Less reports whether a ranks above b.
+	Less(a, b Result) bool
+}
+
+// BonusScorer is a Scorer that also adjusts each result's bonus once it's
+// matched, so that its Less can rank by Score with its own bonuses included.
+type BonusScorer interface {
+	Scorer
+
+	// Bonus returns the amount to add to r's bonus.
+	Bonus(r Result) float64
+}
+
+// ByLength sorts results by the number of runes in their inputs, shortest
+// first, and then by rank.
+type ByLength []Result
+
+func (r ByLength) Len() int {
+	return len(r)
+}
+
+func (r ByLength) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r ByLength) Less(i, j int) bool {
+	if a, b := utf8.RuneCountInString(r[i].Input), utf8.RuneCountInString(r[j].Input); a != b {
+		return a < b
+	}
+	return ByRank(r).Less(i, j)
+}
+
+// ByInput sorts results by their inputs in lexicographic order, and then by
+// rank.
+type ByInput []Result
+
+func (r ByInput) Len() int {
+	return len(r)
+}
+
+func (r ByInput) Swap(i, j int) {
+	r[i], r[j] = r[j], r[i]
+}
+
+func (r ByInput) Less(i, j int) bool {
+	if r[i].Input != r[j].Input {
+		return r[i].Input < r[j].Input
+	}
+	return ByRank(r).Less(i, j)
+}
+
+// Span is a range of runes in a string, as byte offsets.
+type Span struct{ Start, End int }
+
+// Result contains the matches from a search.
+type Result struct {
+	// Input is the string that was searched.
+	Input string
+
+	// Matches contains the spans within the input string where matching
+	// runes were found.
+	Matches []Span
+
+	// Runes, when non-zero, is the number of term runes matched. It's set by
+	// matchers whose spans don't correspond one-to-one with term runes.
+	Runes int
+
+	// Alignments is the number of distinct alignments of the term that
+	// were found in the input.
+	Alignments int
+
+	// Bonus is added to the match score when ranking. It's measured in
+	// matched runes, so a bonus of 1 is worth as much as matching one more
+	// rune of the term.
+	Bonus float64
+
+	// Extra contains spans matched by secondary searches. They're
+	// highlighted but don't contribute to the result's scores.
+	Extra []Span
+
+	// Source is the name of the file the input was read from, or empty
+	// for stdin.
+	Source string
+
+	// Line is the input's 1-based line number within its source.
+	Line int
+
+	// WholeLine highlights the entire input instead of the matched spans.
+	WholeLine bool
+
+	// Escapes contains ANSI escape sequences that were stripped from the
+	// input before it was searched. They're restored when printing.
+	Escapes []Escape
+
+	// index is the input's position among all of a searcher's inputs.
+	index int
+}
+
+// MatchScore is how well the result matches the search term. The score
+// increases for each search term rune that was found in the input.
+func (r Result) MatchScore() int {
+	if r.Runes > 0 {
+		return r.Runes
+	}
+	return matchedRunes(r, Options{})
+}
+
+// SourceName returns the name of the file the input was read from, or
+// "<stdin>" if it was read from stdin.
+func (r Result) SourceName() string {
+	if r.Source == "" {
+		return "<stdin>"
+	}
+	return r.Source
+}
+
+// ID returns a short identifier for the result's input. It's the same for
+// identical inputs, so it can be used to track a result across searches.
+func (r Result) ID() string {
+	h := fnv.New32a()
+	io.WriteString(h, r.Input)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// Score is the match score plus any ranking bonuses.
+func (r Result) Score() float64 {
+	return float64(r.MatchScore()) + r.Bonus
+}
+
+// BoundaryScore is the number of matched spans that start at the start of a
+// word or at a camelCase hump in the input, since matches there are usually the
+// ones that were meant.
+func (r Result) BoundaryScore() int {
+	score := 0
+	for _, m := range r.Matches {
+		if wordStart(r.Input, m.Start) || camelHump(r.Input, m.Start) {
+			score++
+		}
+	}
+	return score
+}
+
+// AtStart reports whether the result's first match is at the start of the
+// input.
+func (r Result) AtStart() bool {
+	return len(r.Matches) > 0 && r.Matches[0].Start == 0
+}
+
+// AtEnd reports whether the result's last match ends at the end of the input.
+// Matching the end is ranked above matching the start, since terms like file
+// extensions are usually typed to find inputs that end with them.
+func (r Result) AtEnd() bool {
+	return len(r.Matches) > 0 && r.Matches[len(r.Matches)-1].End == len(r.Input)
+}
+
+// FirstStart is the byte offset in the input where the result's first match
+// starts, or the input's length if it has no matches.
+func (r Result) FirstStart() int {
+	if len(r.Matches) == 0 {
+		return len(r.Input)
+	}
+	return r.Matches[0].Start
+}
+
+// GapScore is a negative value that corresponds to how many gaps must be
+// inserted into the search term to find a match.
+func (r Result) GapScore() int {
+	return -len(r.Matches) + 1
+}
+
+// runeCount returns the number of runes in s, not counting combining marks,
+// which are displayed as part of the rune before them.
+func runeCount(s string) int {
+	n := 0
+	for _, c := range s {
+		if !isMark(c) {
+			n++
+		}
+	}
+	return n
+}
+
+// LongestSpan is the number of runes in the result's longest matched span,
+// which is the longest run of term runes that matched consecutively.
+func (r Result) LongestSpan() int {
+	longest := 0
+	for _, m := range r.Matches {
+		if n := runeCount(r.Input[m.Start:m.End]); n > longest {
+			longest = n
+		}
+	}
+	return longest
+}
+
+// GapSize is the number of unmatched runes skipped between the result's matched
+// spans, so a tight match has a smaller gap size than a sprawling one with the
+// same number of gaps.
+func (r Result) GapSize() int {
+	size := 0
+	for i := 1; i < len(r.Matches); i++ {
+		if prev, m := r.Matches[i-1], r.Matches[i]; m.Start > prev.End {
+			size += runeCount(r.Input[prev.End:m.Start])
+		}
+	}
+	return size
+}
+
+// FromMatch returns a copy of the result with the part of its input before
+// the first matched rune removed. Spans that were entirely removed are dropped,
+// and escapes that were removed are moved to the start of the input so that
+// the input's colors are still applied.
+func (r Result) FromMatch() Result {
+	if r.WholeLine || len(r.Matches) == 0 || r.Matches[0].Start == 0 {
+		return r
+	}
+	start := r.Matches[0].Start
+	shift := func(spans []Span) []Span {
+		var shifted []Span
+		for _, s := range spans {
+			if s.End <= start {
+				continue
+			}
+			if s.Start < start {
+				s.Start = start
+			}
+			shifted = append(shifted, Span{Start: s.Start - start, End: s.End - start})
+		}
+		return shifted
+	}
+
+	trimmed := r
+	trimmed.Input = r.Input[start:]
+	trimmed.Matches = shift(r.Matches)
+	trimmed.Extra = shift(r.Extra)
+	trimmed.Escapes = make([]Escape, len(r.Escapes))
+	for i, e := range r.Escapes {
+		e.Pos -= start
+		if e.Pos < 0 {
+			e.Pos = 0
+		}
+		trimmed.Escapes[i] = e
+	}
+	return trimmed
+}
+
+// Highlights returns the sorted, non-overlapping spans of the input that
+// should be highlighted.
+func (r Result) Highlights() []Span {
+	if r.WholeLine {
+		return []Span{{Start: 0, End: len(r.Input)}}
+	}
+	if len(r.Extra) == 0 {
+		return r.Matches
+	}
+	all := make([]Span, 0, len(r.Matches)+len(r.Extra))
+	all = append(all, r.Matches...)
+	all = append(all, r.Extra...)
+	sort.Slice(all, func(i, j int) bool { return all[i].Start < all[j].Start })
+
+	merged := all[:1]
+	for _, s := range all[1:] {
+		last := &merged[len(merged)-1]
+		if s.Start > last.End {
+			merged = append(merged, s)
+		} else if s.End > last.End {
+			last.End = s.End
+		}
+	}
+	return merged
+}
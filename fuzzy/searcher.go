@@ -0,0 +1,1195 @@
+package fuzzy
+
+import (
+	"container/heap"
+	"context"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Searcher ranks a stream of inputs against a search term. Inputs are matched
+// concurrently in batches as they're appended. Its exported fields configure
+// the search and must be set before the first call to Append.
+type Searcher struct {
+	// Term is the primary search term.
+	Term string
+
+	// terms holds the whitespace-separated tokens of Term when there's
+	// more than one. Each token is matched independently, and inputs
+	// must match all of them.
+	terms []string
+
+	// Regexp, when set, is matched against inputs instead of Term. The
+	// spans it matches are highlighted, and results are ranked by their
+	// total length.
+	Regexp *regexp.Regexp
+
+	// Then is an optional second search term. When set, only inputs that
+	// also match every rune of each of its whitespace-separated tokens are
+	// kept, and its matches are highlighted alongside the primary term's.
+	Then string
+
+	// Exclude is an optional term that drops any input matching every
+	// rune of it.
+	Exclude string
+
+	// PreserveANSI strips ANSI escape sequences from inputs before
+	// searching them and restores them when printing.
+	PreserveANSI bool
+
+	// Decode, when set, decodes each input before it's searched. Inputs
+	// that fail to decode are skipped. Results show the original input
+	// unless ShowDecoded is set.
+	Decode      func(string) (string, error)
+	ShowDecoded bool
+
+	// Opts configures how terms are matched against each input.
+	Opts Options
+
+	// Weights maps exact inputs to a boost that's added to their match
+	// score when they match.
+	Weights map[string]int
+
+	// CaseRank matches case-insensitively, but ranks results whose case
+	// matches the term's higher.
+	CaseRank bool
+
+	// PathRank ranks results whose matches are in the basename of a path
+	// higher.
+	PathRank bool
+
+	// CountWeight is the bonus given to a result for each alignment of the
+	// term found in its input beyond the first.
+	CountWeight float64
+
+	// PreserveSpace searches inputs exactly as they're appended, rather
+	// than trimming their leading and trailing whitespace first.
+	PreserveSpace bool
+
+	// Unique skips inputs that are identical to one that was already
+	// appended, so each distinct input is ranked at most once.
+	Unique bool
+
+	// MinLen and MaxLen, when positive, skip inputs with fewer or more runes
+	// than them before they're searched.
+	MinLen int
+	MaxLen int
+
+	// Field, when positive, only matches the Field'th field of each input,
+	// counting from 1, where fields are separated by FieldSep. Results
+	// still contain the whole input, with their spans in the field. Inputs
+	// with fewer fields don't match.
+	Field    int
+	FieldSep string
+
+	// seen holds the inputs appended so far when Unique is set.
+	seen map[string]struct{}
+
+	// source is the name of the file that inputs are currently being
+	// appended from, or empty for stdin.
+	source string
+
+	// lines is the number of inputs appended from the current source so
+	// far, including blank ones.
+	lines int
+
+	// total is the number of inputs appended from all sources.
+	total int
+
+	// MaxPerSource, when positive, limits the number of ranked results
+	// from any one source.
+	MaxPerSource int
+
+	// PerTierLimit, when positive, limits the number of ranked results
+	// that share the same score.
+	PerTierLimit int
+
+	// matched is the number of inputs that matched. It's updated
+	// atomically as batches deliver their results.
+	matched int64
+
+	// TopN, when positive, has each batch keep only its best TopN results
+	// in a bounded heap rather than returning a result for every input.
+	TopN int
+
+	// TwoPhase first scores inputs with cheapMatch, and then only fully
+	// matches the TopN of them with the highest scores and any tied with
+	// those, or all of them when TopN isn't positive.
+	TwoPhase bool
+
+	// AllSpans highlights every match of the whole term in each input,
+	// rather than only the best match. The other matches don't overlap the
+	// best one or each other, and they're added to Extra so they don't
+	// affect ranking. It has no effect with multiple tokens, Ngram or
+	// Positional, and a Regexp already highlights all of its matches.
+	AllSpans bool
+
+	// PreferShallow breaks ties between results that only differ in their
+	// inputs by ranking those with fewer slashes first, before comparing
+	// their lengths, so that shallower paths win. Results are then sorted
+	// like ByDepth rather than ByRank.
+	PreferShallow bool
+
+	// NonMatching inverts the search, so that its results are the inputs
+	// that don't match, in the order they were appended and without any
+	// spans.
+	NonMatching bool
+
+	// Sort, when set, returns the order to sort results in instead of
+	// ByRank. Every matching input is then kept until they're sorted, and
+	// TopN and TwoPhase are ignored since they only keep the best ranked.
+	Sort func([]Result) sort.Interface
+
+	// Scorer, when set, ranks results instead of ByRank. Like Sort, every
+	// matching input is kept until they're ranked, and TopN and TwoPhase
+	// are ignored. Sort takes precedence over it.
+	Scorer Scorer
+
+	// Jobs is the most batches that are matched concurrently.
+	Jobs int
+
+	// BatchBytes is how many bytes of inputs are collected into a batch
+	// before it's matched.
+	BatchBytes int
+
+	batch        []line
+	batchBytes   int
+	batchCount   int
+	batchSem     chan struct{}
+	batchResults chan batchResult
+
+	// done is closed when the search is cancelled, which stops batches
+	// from matching any more inputs or waiting to deliver their results.
+	done chan struct{}
+}
+
+// validUTF8 returns s with each run of bytes that aren't valid UTF-8 replaced
+// with U+FFFD.
+func validUTF8(s string) string {
+	if utf8.ValidString(s) {
+		return s
+	}
+	return strings.ToValidUTF8(s, string(utf8.RuneError))
+}
+
+// New returns a Searcher for term. Inputs must match every
+// whitespace-separated token in term when it has more than one. Batches of
+// 256000 bytes are matched by as many jobs as there are CPUs.
+func New(term string) *Searcher {
+	s := &Searcher{
+		Term:         validUTF8(term),
+		Jobs:         runtime.NumCPU(),
+		BatchBytes:   256000,
+		batchResults: make(chan batchResult),
+		done:         make(chan struct{}),
+	}
+	if tokens := strings.Fields(s.Term); len(tokens) > 1 {
+		s.terms = tokens
+	}
+	return s
+}
+
+// line is a single input along with where it came from.
+type line struct {
+	text string
+
+	// source is the name of the file the input was read from, or empty
+	// for stdin.
+	source string
+
+	// num is the input's 1-based line number within its source.
+	num int
+
+	// index is the input's 1-based position among the inputs appended from
+	// all sources.
+	index int
+}
+
+// StartSource begins appending inputs from a new source.
+func (s *Searcher) StartSource(name string) {
+	s.source = name
+	s.lines = 0
+}
+
+// Append adds inputs from the current source to the search. Inputs are
+// buffered and matched in the background once enough have accumulated. Bytes
+// that aren't valid UTF-8 are replaced with U+FFFD, so results can be printed
+// without splitting runes, and their inputs and spans are those of the
+// replaced form.
+func (s *Searcher) Append(input ...string) {
+	for _, elem := range input {
+		s.lines++
+		s.total++
+		elem = validUTF8(elem)
+		if !s.PreserveSpace {
+			elem = strings.TrimSpace(elem)
+		}
+		if elem == "" || !s.inRange(elem) {
+			continue
+		}
+		if s.Unique {
+			if _, ok := s.seen[elem]; ok {
+				continue
+			}
+			if s.seen == nil {
+				s.seen = make(map[string]struct{})
+			}
+			s.seen[elem] = struct{}{}
+		}
+
+		s.batch = append(s.batch, line{text: elem, source: s.source, num: s.lines, index: s.total})
+		s.batchBytes += len(elem)
+		if s.batchBytes >= s.BatchBytes {
+			s.sem() <- struct{}{}
+			s.batchCount++
+			// The channels are passed in so that a batch left
+			// running by a cancelled search can't deliver its
+			// results to the next search after a Reset.
+			go func(batch []line, out chan<- batchResult, done <-chan struct{}) {
+				results := s.matchBatch(batch, done)
+				<-s.batchSem
+				select {
+				case out <- results:
+				case <-done:
+				}
+			}(s.batch, s.batchResults, s.done)
+			s.batch = make([]line, 0, cap(s.batch))
+			s.batchBytes = 0
+		}
+	}
+}
+
+// inRange reports whether the number of runes in input is within MinLen and
+// MaxLen.
+func (s *Searcher) inRange(input string) bool {
+	if s.MinLen <= 0 && s.MaxLen <= 0 {
+		return true
+	}
+	n := utf8.RuneCountInString(input)
+	return (s.MinLen <= 0 || n >= s.MinLen) && (s.MaxLen <= 0 || n <= s.MaxLen)
+}
+
+// sem returns the semaphore that limits how many batches are matched at once,
+// creating it with a slot for each of Jobs the first time it's needed.
+func (s *Searcher) sem() chan struct{} {
+	if s.batchSem == nil {
+		jobs := s.Jobs
+		if jobs < 1 {
+			jobs = 1
+		}
+		s.batchSem = make(chan struct{}, jobs)
+	}
+	return s.batchSem
+}
+
+// batchResult is what a batch delivers once it's been matched.
+type batchResult struct {
+	results []Result
+
+	// matched is the number of the batch's inputs that matched, which is
+	// more than len(results) when TopN drops some of them.
+	matched int64
+}
+
+// matchBatch matches every line in a batch, stopping early if done is closed.
+// If TopN is set, only the best TopN results are returned.
+func (s *Searcher) matchBatch(batch []line, done <-chan struct{}) batchResult {
+	match := s.matcher()
+
+	var results []Result
+	var matched int64
+	if s.TopN > 0 && s.ranked() {
+		top := s.newTop(s.TopN)
+		for _, b := range batch {
+			if cancelled(done) {
+				break
+			}
+			if r, ok := match(b); ok {
+				top.add(r)
+				matched++
+			}
+		}
+		results = top.all()
+	} else {
+		results = make([]Result, 0, len(batch))
+		for _, b := range batch {
+			if cancelled(done) {
+				break
+			}
+			if r, ok := match(b); ok {
+				results = append(results, r)
+				matched++
+			}
+		}
+	}
+	return batchResult{results: results, matched: matched}
+}
+
+// passThrough reports whether the term is empty. Every input matches an empty
+// term without any spans, and results keep the order their inputs were
+// appended in rather than being ranked.
+func (s *Searcher) passThrough() bool {
+	return s.Term == ""
+}
+
+// matcher returns the function that matches inputs as they're appended. The
+// first phase of a two-phase search uses cheapMatch.
+func (s *Searcher) matcher() func(line) (Result, bool) {
+	switch {
+	case s.NonMatching:
+		return s.nonMatch
+	case s.TwoPhase && s.ranked():
+		return s.cheapMatch
+	}
+	return s.match
+}
+
+// nonMatch is the inverse of match for NonMatching. It returns a result
+// without any spans for an input that doesn't match, or false if it does.
+func (s *Searcher) nonMatch(l line) (Result, bool) {
+	if _, ok := s.match(l); ok {
+		return Result{}, false
+	}
+	return Result{Input: l.text, Source: l.source, Line: l.num, index: l.index}, true
+}
+
+// ranked reports whether results are sorted by ByRank, which lets only the best
+// ranked of them be kept while inputs are being matched.
+func (s *Searcher) ranked() bool {
+	return !s.passThrough() && !s.NonMatching && s.Sort == nil && s.Scorer == nil
+}
+
+// cancelled reports whether a search has been cancelled by closing done.
+func cancelled(done <-chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// RankedResults waits for all appended inputs to be matched and returns the
+// results in rank order, keeping at most max of them when max is positive. An
+// empty term returns every input in the order it was appended instead. It must
+// only be called once per search, after the last call to Append, and Reset
+// starts another search.
+func (s *Searcher) RankedResults(max int) []Result {
+	results, _ := s.RankedResultsContext(context.Background(), max)
+	return results
+}
+
+// RankedResultsContext is like RankedResults, but gives up waiting for inputs
+// to be matched once ctx is done. Batches that are still running stop matching
+// as soon as they notice, and ctx's error is returned without any results.
+func (s *Searcher) RankedResultsContext(ctx context.Context, max int) ([]Result, error) {
+	return s.collect(ctx, max, nil, nil)
+}
+
+// StreamResults appends each input received from inputs to the current source
+// until inputs is closed, and then returns the results like
+// RankedResultsContext. While it does, progress is called with the best
+// results so far each time a batch of inputs finishes matching, so results can
+// be shown before all of the inputs have been read. The final results are the
+// same as RankedResults would return. Like RankedResults, it must only be
+// called once per search, and Append mustn't be called while it's running.
+func (s *Searcher) StreamResults(ctx context.Context, inputs <-chan string, max int, progress func([]Result)) ([]Result, error) {
+	return s.collect(ctx, max, inputs, progress)
+}
+
+// ForEachResult waits for all appended inputs to be matched like RankedResults,
+// and then calls fn with each of the results in rank order until fn returns
+// false or max results have been passed to it, when max is positive. Results
+// ranked the usual way are popped off a heap one at a time, so the ones after
+// fn stops are never sorted. Like RankedResults, it must only be called once
+// per search.
+func (s *Searcher) ForEachResult(max int, fn func(Result) bool) {
+	all, _ := s.gather(context.Background(), max, nil, nil)
+	if !s.ranked() || s.TwoPhase || s.MaxPerSource > 0 || s.PerTierLimit > 0 {
+		for _, r := range s.finish(all, max) {
+			if !fn(r) {
+				return
+			}
+		}
+		return
+	}
+	h := &rankHeap{results: all, shallow: s.PreferShallow}
+	heap.Init(h)
+	for n := 0; h.Len() > 0 && (max <= 0 || n < max); n++ {
+		if !fn(heap.Pop(h).(Result)) {
+			return
+		}
+	}
+}
+
+// collect gathers the results of a search and puts them in the searcher's
+// order.
+func (s *Searcher) collect(ctx context.Context, max int, inputs <-chan string, progress func([]Result)) ([]Result, error) {
+	all, err := s.gather(ctx, max, inputs, progress)
+	if err != nil {
+		return nil, err
+	}
+	return s.finish(all, max), nil
+}
+
+// gather appends inputs until it's closed, if it isn't nil, while collecting
+// the results of batches as they're matched, and calls progress, if it isn't
+// nil, with the results so far after each batch. The results it returns aren't
+// ordered yet.
+func (s *Searcher) gather(ctx context.Context, max int, inputs <-chan string, progress func([]Result)) (ByRank, error) {
+	// If ctx is done by the time the search finishes, batches may still be
+	// waiting to deliver their results, so they're released either way.
+	finished, done := make(chan struct{}), s.done
+	defer close(finished)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-finished:
+			if ctx.Err() == nil {
+				return
+			}
+		}
+		close(done)
+	}()
+
+	match := s.matcher()
+	// Unless results are capped after they're ranked, only the best of
+	// them can make the cut, so they're kept in a bounded heap as they
+	// arrive rather than all being held until they're sorted.
+	var top *topResults
+	switch {
+	case !s.ranked():
+		// The heap keeps the best ranked results, not the first ones
+		// in another order.
+	case s.TwoPhase:
+		// Without a TopN, every candidate is reranked.
+		if s.TopN > 0 {
+			top = s.newTop(s.TopN)
+		}
+	case max > 0 && s.MaxPerSource == 0 && s.PerTierLimit == 0:
+		top = s.newTop(max)
+	}
+	all := ByRank([]Result{})
+	add := func(r Result) {
+		if top != nil {
+			top.add(r)
+		} else {
+			all = append(all, r)
+		}
+	}
+	// The results so far are ordered on a copy, since ordering them can
+	// reorder or reuse the slice that's still being added to.
+	report := func() {
+		if progress == nil {
+			return
+		}
+		sofar := all
+		if top != nil {
+			sofar = top.all()
+		}
+		sofar = append(ByRank(nil), sofar...)
+		if s.TwoPhase && s.ranked() {
+			sofar, _ = s.rerank(sofar)
+		}
+		progress(s.order(sofar, max))
+	}
+
+	for received := 0; inputs != nil || received < s.batchCount; {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		select {
+		case in, ok := <-inputs:
+			if !ok {
+				inputs = nil
+				continue
+			}
+			s.Append(in)
+		case b := <-s.batchResults:
+			for _, r := range b.results {
+				add(r)
+			}
+			atomic.AddInt64(&s.matched, b.matched)
+			received++
+			report()
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	// The inputs that didn't fill a batch are matched last, since it isn't
+	// known that they're the last ones until inputs is closed.
+	for _, b := range s.batch {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if r, ok := match(b); ok {
+			add(r)
+			atomic.AddInt64(&s.matched, 1)
+		}
+	}
+	if top != nil {
+		all = top.all()
+	}
+	return all, nil
+}
+
+// finish reranks the gathered results of a two-phase search and orders them.
+func (s *Searcher) finish(all ByRank, max int) []Result {
+	// The candidates that the second phase rejects aren't matches. The
+	// ones it never fully matches are still counted.
+	if s.TwoPhase && s.ranked() {
+		var rejected int
+		all, rejected = s.rerank(all)
+		atomic.AddInt64(&s.matched, -int64(rejected))
+	}
+	return s.order(all, max)
+}
+
+// order sorts results in the searcher's order, caps them by source and tier
+// and keeps at most max of them when max is positive.
+func (s *Searcher) order(all ByRank, max int) []Result {
+	switch {
+	case s.Sort != nil:
+		sort.Sort(s.Sort(all))
+	case s.Scorer != nil:
+		sort.SliceStable(all, func(i, j int) bool { return s.Scorer.Less(all[i], all[j]) })
+	case s.passThrough() || s.NonMatching:
+		sort.Slice(all, func(i, j int) bool { return all[i].index < all[j].index })
+	default:
+		s.sortRanked(all)
+	}
+	if s.MaxPerSource > 0 {
+		all = capPerSource(all, s.MaxPerSource)
+	}
+	if s.PerTierLimit > 0 {
+		all = capPerTier(all, s.PerTierLimit, s.PreferShallow)
+	}
+	if max > 0 && len(all) > max {
+		return all[:max]
+	}
+	return all
+}
+
+// Count waits for all appended inputs to be matched and returns how many of
+// them matched, without ranking them. In a two-phase search, it counts the
+// inputs that the first phase matched. Like RankedResults, it must only be
+// called once per search, after the last call to Append.
+func (s *Searcher) Count() int {
+	match := s.matcher()
+	for _, b := range s.batch {
+		if _, ok := match(b); ok {
+			atomic.AddInt64(&s.matched, 1)
+		}
+	}
+	for i := 0; i < s.batchCount; i++ {
+		b := <-s.batchResults
+		atomic.AddInt64(&s.matched, b.matched)
+	}
+	return s.Matched()
+}
+
+// Reset discards the inputs appended so far along with their results, so that
+// another search can be made with the same configuration. It must only be
+// called once RankedResults, RankedResultsContext or Count has returned.
+func (s *Searcher) Reset() {
+	s.seen = nil
+	s.source = ""
+	s.lines, s.total = 0, 0
+	atomic.StoreInt64(&s.matched, 0)
+	s.batch = s.batch[:0]
+	s.batchBytes, s.batchCount = 0, 0
+	s.batchResults = make(chan batchResult)
+	s.done = make(chan struct{})
+}
+
+// sortRanked sorts results by ByRank, or by ByDepth when PreferShallow is set.
+func (s *Searcher) sortRanked(results []Result) {
+	if s.PreferShallow {
+		sort.Sort(ByDepth(results))
+	} else {
+		sort.Sort(ByRank(results))
+	}
+}
+
+// capPerSource filters ranked results so that no more than max come from any
+// one source, keeping the highest ranked results from each.
+func capPerSource(results []Result, max int) []Result {
+	counts := make(map[string]int)
+	kept := results[:0]
+	for _, r := range results {
+		if counts[r.Source] < max {
+			counts[r.Source]++
+			kept = append(kept, r)
+		}
+	}
+	return kept
+}
+
+// capPerTier filters ranked results so that no more than max share the same
+// score. Within a tier, results are chosen by rank and then lexicographically,
+// so the same inputs are kept regardless of the order they were ranked in.
+func capPerTier(results []Result, max int, shallow bool) []Result {
+	kept := results[:0]
+	for start := 0; start < len(results); {
+		end := start + 1
+		for end < len(results) && results[end].Score() == results[start].Score() {
+			end++
+		}
+
+		tier := results[start:end]
+		sort.SliceStable(tier, func(i, j int) bool {
+			if rankLess(&tier[i], &tier[j], shallow) {
+				return true
+			}
+			return !rankLess(&tier[j], &tier[i], shallow) && tier[i].Input < tier[j].Input
+		})
+		if len(tier) > max {
+			tier = tier[:max]
+		}
+		kept = append(kept, tier...)
+		start = end
+	}
+	return kept
+}
+
+// prepare returns the text of an input line that should be searched, along
+// with any escapes that were stripped from it. It returns false if the input
+// can't be searched at all or is excluded.
+func (s *Searcher) prepare(l line) (input string, escapes []Escape, ok bool) {
+	input = l.text
+	if s.PreserveANSI {
+		input, escapes = stripANSI(input)
+	}
+	if s.Decode != nil {
+		decoded, err := s.Decode(input)
+		if err != nil {
+			return "", nil, false
+		}
+		input = decoded
+	}
+	if s.Exclude != "" && matchesAll(input, s.Exclude, s.Opts) {
+		return "", nil, false
+	}
+	return input, escapes, true
+}
+
+// matchesAll reports whether every rune of term matches input. Only the
+// options that change which runes match each other are used.
+func matchesAll(input, term string, opts Options) bool {
+	opts = Options{Leet: opts.Leet, FoldCase: opts.FoldCase, FoldDiacritics: opts.FoldDiacritics}
+	_, ok := fullMatch(input, term, opts)
+	return ok
+}
+
+// fullMatch is like bestMatch, but it only accepts an alignment of every rune
+// of term.
+func fullMatch(input, term string, opts Options) (Result, bool) {
+	r, ok := bestMatch(input, term, opts)
+	if !ok {
+		return Result{}, false
+	}
+	if opts.FoldDiacritics {
+		term = stripMarks(term)
+	}
+	return r, matchedRunes(r, opts) == utf8.RuneCountInString(term)
+}
+
+// cheapMatch is a fast approximation of match used by the first phase of a
+// two-phase search. It only makes a single greedy alignment of each term, which
+// finds at least the match score that match does but can overestimate the
+// gaps, so candidates are only compared by their scores. The result's bonus is
+// the most that match's bonuses could add, which makes its score an upper bound
+// on the score match would give it. Inputs that don't contain the term's first
+// rune are rejected by the first rune lookup without any further work.
+func (s *Searcher) cheapMatch(l line) (Result, bool) {
+	input, _, ok := s.prepare(l)
+	if !ok {
+		return Result{}, false
+	}
+	start, end, ok := s.field(input)
+	if !ok {
+		return Result{}, false
+	}
+	input = input[start:end]
+
+	// Don't reject alignments here, since the full matcher might find an
+	// acceptable one later in the input.
+	opts := s.Opts
+	opts.Window, opts.PrefixRunes = 0, 0
+	terms := s.terms
+	if len(terms) == 0 {
+		terms = []string{s.Term}
+	}
+	var res Result
+	for _, term := range terms {
+		r, _ := align(input, term, opts, 0)
+		n := matchedRunes(r, opts)
+		if n == 0 {
+			return Result{}, false
+		}
+		res.Matches = append(res.Matches, r.Matches...)
+		res.Runes += n
+	}
+	res.Input = l.text
+	res.Source = l.source
+	res.Line = l.num
+	res.Bonus = float64(s.Weights[l.text]) + s.maxBonus(input)
+	return res, true
+}
+
+// maxBonus returns the most that match's ranking bonuses, apart from weights,
+// could add to the result for input.
+func (s *Searcher) maxBonus(input string) float64 {
+	bonus := 0.0
+	if s.Regexp == nil && len(s.terms) == 0 && s.Opts.Ngram == 0 {
+		// The substring bonus needs the whole term in one span. Marks
+		// can split a folded substring, so it's assumed to be possible
+		// when they're folded.
+		if _, ok := substringMatch(input, s.Term, s.Opts); ok || s.Opts.FoldDiacritics {
+			bonus++
+		}
+		// Every alignment starts at a rune matching the term's first
+		// rune.
+		if s.CountWeight > 0 {
+			first, _ := utf8.DecodeRuneInString(s.Term)
+			n := 0
+			for rest := input; ; n++ {
+				i := indexRune(rest, first, s.Opts)
+				if i == -1 {
+					break
+				}
+				_, size := utf8.DecodeRuneInString(rest[i:])
+				rest = rest[i+size:]
+			}
+			if n > 1 {
+				bonus += s.CountWeight * float64(n-1)
+			}
+		}
+	}
+	if s.CaseRank {
+		weight := 0.1
+		if hasUpper(s.Term) {
+			weight = 0.5
+		}
+		bonus += weight
+	}
+	if s.PathRank {
+		bonus += 0.5
+	}
+	return bonus
+}
+
+// rerank is the second phase of a two-phase search. It fully matches the TopN
+// candidates found by cheapMatch with the highest scores, along with any tied
+// with the last of them, or all of them without a TopN. Since the candidates'
+// scores are upper bounds, a candidate that's left out can't outscore the ones
+// that are fully matched. It also returns how many candidates the full match
+// rejected.
+func (s *Searcher) rerank(candidates []Result) ([]Result, int) {
+	if s.TopN > 0 && len(candidates) > s.TopN {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Score() > candidates[j].Score() })
+		n, cutoff := s.TopN, candidates[s.TopN-1].Score()
+		for n < len(candidates) && candidates[n].Score() == cutoff {
+			n++
+		}
+		candidates = candidates[:n]
+	}
+	checked := len(candidates)
+	results := candidates[:0]
+	for _, c := range candidates {
+		if r, ok := s.match(line{text: c.Input, source: c.Source, num: c.Line}); ok {
+			results = append(results, r)
+		}
+	}
+	return results, checked - len(results)
+}
+
+// topResults is a bounded heap that keeps the best max results added to it.
+// The root of the heap is the worst of the kept results, so it's the one
+// replaced when a better result is added to a full heap.
+type topResults struct {
+	results []Result
+	max     int
+
+	// shallow ranks results like ByDepth rather than ByRank.
+	shallow bool
+
+	// bounds compares results only by their scores, which are upper bounds
+	// for the candidates of a two-phase search. Since how tied candidates
+	// rank isn't known until they're fully matched, every result tied
+	// with the worst kept one is kept in tied instead of being dropped.
+	bounds bool
+	tied   []Result
+}
+
+// newTop returns a heap for the best max results of a search.
+func (s *Searcher) newTop(max int) *topResults {
+	return &topResults{max: max, shallow: s.PreferShallow, bounds: s.TwoPhase}
+}
+
+// all returns every result kept by the heap, in no particular order.
+func (t *topResults) all() []Result {
+	if len(t.tied) == 0 {
+		return t.results
+	}
+	return append(t.results, t.tied...)
+}
+
+// less reports whether a ranks above b in the heap's order.
+func (t *topResults) less(a, b *Result) bool {
+	if t.bounds {
+		return a.Score() > b.Score()
+	}
+	return rankLess(a, b, t.shallow)
+}
+
+func (t *topResults) Len() int {
+	return len(t.results)
+}
+
+func (t *topResults) Less(i, j int) bool {
+	return t.less(&t.results[j], &t.results[i])
+}
+
+func (t *topResults) Swap(i, j int) {
+	t.results[i], t.results[j] = t.results[j], t.results[i]
+}
+
+func (t *topResults) Push(x interface{}) {
+	t.results = append(t.results, x.(Result))
+}
+
+func (t *topResults) Pop() interface{} {
+	last := t.results[len(t.results)-1]
+	t.results = t.results[:len(t.results)-1]
+	return last
+}
+
+// add adds r to the heap if it's full, or if r ranks higher than the worst
+// result in the heap.
+func (t *topResults) add(r Result) {
+	if len(t.results) < t.max {
+		heap.Push(t, r)
+		return
+	}
+	worst := t.results[0]
+	switch {
+	case t.less(&r, &worst):
+		t.results[0] = r
+		heap.Fix(t, 0)
+		if !t.bounds {
+			break
+		}
+		// The tied results are only kept while they're tied with
+		// the worst of the heap.
+		if root := t.results[0]; root.Score() == worst.Score() {
+			t.tied = append(t.tied, worst)
+		} else {
+			t.tied = t.tied[:0]
+		}
+	case t.bounds && r.Score() == worst.Score():
+		t.tied = append(t.tied, r)
+	}
+}
+
+// rankHeap is a heap with the best ranked of its results at the root, so they
+// can be popped in rank order without sorting all of them.
+type rankHeap struct {
+	results []Result
+
+	// shallow ranks results like ByDepth rather than ByRank.
+	shallow bool
+}
+
+func (h *rankHeap) Len() int {
+	return len(h.results)
+}
+
+func (h *rankHeap) Less(i, j int) bool {
+	return rankLess(&h.results[i], &h.results[j], h.shallow)
+}
+
+func (h *rankHeap) Swap(i, j int) {
+	h.results[i], h.results[j] = h.results[j], h.results[i]
+}
+
+func (h *rankHeap) Push(x interface{}) {
+	h.results = append(h.results, x.(Result))
+}
+
+func (h *rankHeap) Pop() interface{} {
+	last := h.results[len(h.results)-1]
+	h.results = h.results[:len(h.results)-1]
+	return last
+}
+
+// Match returns the result for a single input using the searcher's
+// configuration, or false if it doesn't match. The input isn't added to the
+// searcher's results, and like Append, invalid UTF-8 in it is replaced.
+func (s *Searcher) Match(input string) (Result, bool) {
+	return s.match(line{text: validUTF8(input)})
+}
+
+// termRunes returns the number of runes in the term, not counting combining
+// marks when they're folded.
+func (s *Searcher) termRunes() int {
+	if s.Opts.FoldDiacritics {
+		return utf8.RuneCountInString(stripMarks(s.Term))
+	}
+	return utf8.RuneCountInString(s.Term)
+}
+
+// NormalizedScore returns the fraction of the term's runes that the result
+// matched, from 0 to 1, so that results can be compared with a number that
+// doesn't depend on the length of the term. Whitespace between the term's
+// tokens isn't counted, and each token's runes count even when its match
+// overlaps another's, since the match score sums them. Regular expressions and
+// an empty term always match fully, so their results score 1.
+func (s *Searcher) NormalizedScore(r Result) float64 {
+	term := strings.Join(strings.Fields(s.Term), "")
+	if s.Opts.FoldDiacritics {
+		term = stripMarks(term)
+	}
+	n := utf8.RuneCountInString(term)
+	if s.Regexp != nil || n == 0 {
+		return 1
+	}
+	score := float64(r.MatchScore()) / float64(n)
+	if score > 1 {
+		score = 1
+	}
+	return score
+}
+
+// Total returns the number of inputs appended from all sources, including
+// blank ones.
+func (s *Searcher) Total() int {
+	return s.total
+}
+
+// Matched returns the number of appended inputs that matched. It's only
+// complete once RankedResults has returned. In a two-phase search, it doesn't
+// count the candidates that the second phase rejected.
+func (s *Searcher) Matched() int {
+	return int(atomic.LoadInt64(&s.matched))
+}
+
+// Batches returns the number of batches that appended inputs were split into
+// for matching concurrently.
+func (s *Searcher) Batches() int {
+	return s.batchCount
+}
+
+// match returns the best result for an input line, or false if it doesn't
+// match the searcher's terms.
+func (s *Searcher) match(l line) (Result, bool) {
+	input, escapes, ok := s.prepare(l)
+	if !ok {
+		return Result{}, false
+	}
+	start, end, ok := s.field(input)
+	if !ok {
+		return Result{}, false
+	}
+	text := input[start:end]
+
+	var res Result
+	switch {
+	case s.passThrough():
+		res, ok = Result{}, true
+	case s.Regexp != nil:
+		res, ok = regexpMatch(text, s.Regexp)
+	case len(s.terms) > 0 && !s.Opts.Positional:
+		res, ok = andMatch(text, s.terms, s.Opts)
+	default:
+		res, ok = bestMatch(text, s.Term, s.Opts)
+	}
+	if !ok {
+		return Result{}, false
+	}
+	res.Input = input
+	res.Matches = shiftSpans(res.Matches, start)
+	res.Line = l.num
+	res.Source = l.source
+	res.index = l.index
+	res.Escapes = escapes
+	res.Bonus += s.CountWeight * float64(res.Alignments-1)
+	if s.Regexp == nil && len(s.terms) == 0 && s.Opts.Ngram == 0 && len(res.Matches) == 1 && matchedRunes(res, s.Opts) == s.termRunes() {
+		// The whole term appearing as a substring is almost always the
+		// match that was meant, so it's worth a whole matched rune.
+		// That's as much as the case and path bonuses can add together,
+		// so a scattered match can only tie with it by having both in
+		// full when the substring has neither.
+		res.Bonus++
+	}
+	if s.CaseRank {
+		res.Bonus += caseBonus(res, s.Term, s.Opts)
+	}
+	if s.PathRank {
+		res.Bonus += basenameBonus(res)
+	}
+	res.Bonus += float64(s.Weights[l.text])
+	if b, ok := s.Scorer.(BonusScorer); ok {
+		res.Bonus += b.Bonus(res)
+	}
+	if s.Decode != nil {
+		// Spans can't be mapped back onto the encoded input, so the
+		// whole line is highlighted instead.
+		res.WholeLine = !s.passThrough()
+		if !s.ShowDecoded {
+			res.Input = l.text
+			res.Escapes = nil
+		}
+	}
+
+	if s.AllSpans && s.Regexp == nil && len(s.terms) == 0 && s.Opts.Ngram == 0 && !s.Opts.Positional && len(res.Matches) > 0 {
+		first, last := res.Matches[0].Start-start, res.Matches[len(res.Matches)-1].End-start
+		res.Extra = append(s.occurrences(text, 0, first), s.occurrences(text, last, len(text))...)
+		res.Extra = shiftSpans(res.Extra, start)
+	}
+
+	// The second stage runs the full search independently against the
+	// same input, and every rune of each of its tokens has to match. It
+	// doesn't affect ranking, but its matches are highlighted too.
+	for _, token := range strings.Fields(s.Then) {
+		then, ok := fullMatch(text, token, s.Opts)
+		if !ok {
+			return Result{}, false
+		}
+		res.Extra = append(res.Extra, shiftSpans(then.Matches, start)...)
+	}
+	return res, true
+}
+
+// occurrences returns the spans of every match of the whole term in the part
+// of text from lo to hi, where matches don't overlap each other. The best match
+// is found first, and then the parts before and after it are searched again.
+func (s *Searcher) occurrences(text string, lo, hi int) []Span {
+	term := s.Term
+	if s.Opts.FoldDiacritics {
+		term = stripMarks(term)
+	}
+	var r Result
+	var ok bool
+	if s.Opts.Substring {
+		r, ok = substringMatch(text[lo:hi], term, s.Opts)
+		r.Input, r.Matches = text, shiftSpans(r.Matches, lo)
+	} else {
+		r, ok = search(text[:hi], term, s.Opts, lo)
+	}
+	if !ok || matchedRunes(r, s.Opts) < utf8.RuneCountInString(term) {
+		return nil
+	}
+
+	first, last := r.Matches[0].Start, r.Matches[len(r.Matches)-1].End
+	spans := s.occurrences(text, lo, first)
+	spans = append(spans, r.Matches...)
+	return append(spans, s.occurrences(text, last, hi)...)
+}
+
+// field returns the byte offsets of the part of input that's matched, which is
+// the whole input unless Field is set. It returns false if Field is set and the
+// input has fewer fields.
+func (s *Searcher) field(input string) (start, end int, ok bool) {
+	if s.Field <= 0 {
+		return 0, len(input), true
+	}
+	for n := 1; n < s.Field; n++ {
+		i := strings.Index(input[start:], s.FieldSep)
+		if i == -1 {
+			return 0, 0, false
+		}
+		start += i + len(s.FieldSep)
+	}
+	end = len(input)
+	if i := strings.Index(input[start:], s.FieldSep); i != -1 {
+		end = start + i
+	}
+	return start, end, true
+}
+
+// shiftSpans moves spans forward by offset bytes, in place.
+func shiftSpans(spans []Span, offset int) []Span {
+	if offset == 0 {
+		return spans
+	}
+	for i := range spans {
+		spans[i].Start += offset
+		spans[i].End += offset
+	}
+	return spans
+}
+
+// hasUpper reports whether s contains any uppercase runes.
+func hasUpper(s string) bool {
+	return strings.IndexFunc(s, unicode.IsUpper) != -1
+}
+
+// caseBonus returns a ranking bonus for how many of a result's matched runes
+// have the same case as the term runes they matched. The bonus is always less
+// than one matched rune, so it only reorders results that match equally well.
+// It's stronger when the term contains uppercase runes, since that's a sign the
+// case was typed deliberately. The whitespace between a term's tokens is never
+// matched, so it's left out when lining matched runes up with term runes, and
+// so are combining marks when diacritics are folded, like matchedRunes.
+func caseBonus(r Result, term string, opts Options) float64 {
+	term = strings.Join(strings.Fields(term), "")
+	if opts.FoldDiacritics {
+		term = stripMarks(term)
+	}
+	termRunes := []rune(term)
+	if len(termRunes) == 0 {
+		return 0
+	}
+
+	weight := 0.1
+	if hasUpper(term) {
+		weight = 0.5
+	}
+
+	// Runes are compared with only their diacritics folded, so that a
+	// letter matched without its accent still counts its case.
+	diacritics := Options{FoldDiacritics: opts.FoldDiacritics}
+	same, i := 0, 0
+	for _, m := range r.Matches {
+		for _, c := range r.Input[m.Start:m.End] {
+			if opts.FoldDiacritics && isMark(c) {
+				continue
+			}
+			if i < len(termRunes) && diacritics.fold(c) == diacritics.fold(termRunes[i]) {
+				same++
+			}
+			i++
+		}
+	}
+	return weight * float64(same) / float64(len(termRunes))
+}
+
+// basenameBonus is a ranking bonus for a result whose matched runes are in the
+// last segment of its input when treated as a path. It's proportional to the
+// fraction of matched runes after the input's final slash. Like caseBonus, it's
+// less than one matched rune.
+func basenameBonus(r Result) float64 {
+	base := strings.LastIndexByte(r.Input, '/') + 1
+	in, total := 0, 0
+	for _, m := range r.Matches {
+		total += utf8.RuneCountInString(r.Input[m.Start:m.End])
+		if m.End > base {
+			start := m.Start
+			if start < base {
+				start = base
+			}
+			in += utf8.RuneCountInString(r.Input[start:m.End])
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 0.5 * float64(in) / float64(total)
+}
@@ -0,0 +1,656 @@
+package fuzzy
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gcurtis/fz/internal/corpus"
+)
+
+// maxResults and twoPhaseCandidates mirror fz's defaults, so that searches
+// are tested the way the command runs them.
+const (
+	maxResults         = 25
+	twoPhaseCandidates = 20
+)
+
+func TestAppendBlank(t *testing.T) {
+	s := New("o")
+	s.Append("foo", "", "bar", "  ", "boo")
+	var got []string
+	for _, r := range s.RankedResults(maxResults) {
+		got = append(got, r.Input)
+	}
+	sort.Strings(got)
+	if want := []string{"boo", "foo"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got results %q, want %q", got, want)
+	}
+
+	s = New("bar")
+	s.Append("foo", "", "bar")
+	if got := s.RankedResults(maxResults); len(got) != 1 || got[0].Input != "bar" {
+		t.Errorf("got results %v, want bar to be searched after a blank input", got)
+	}
+}
+
+func TestBatchedNonMatches(t *testing.T) {
+	var corpus []string
+	for i := 0; i < 10; i++ {
+		corpus = append(corpus, fmt.Sprintf("moo%d", i), "dog", "cat")
+	}
+	ranked := func(batchBytes int) []string {
+		s := New("moo")
+		s.BatchBytes = batchBytes
+		for _, c := range corpus {
+			s.Append(c)
+		}
+		var inputs []string
+		for _, r := range s.RankedResults(maxResults) {
+			if r.Input == "" {
+				t.Errorf("got an empty result with batches of %d bytes", batchBytes)
+			}
+			inputs = append(inputs, r.Input)
+		}
+		sort.Strings(inputs)
+		return inputs
+	}
+
+	want := ranked(256000)
+	if len(want) != 10 {
+		t.Fatalf("got %d unbatched results, want 10", len(want))
+	}
+	if got := ranked(8); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got batched results %q, want %q", got, want)
+	}
+}
+
+func TestEmptyTerm(t *testing.T) {
+	var corpus []string
+	for i := 0; i < 100; i++ {
+		corpus = append(corpus, fmt.Sprintf("line %03d", 99-i))
+	}
+	for _, twoPhase := range []bool{false, true} {
+		s := New("")
+		s.BatchBytes = 64
+		s.TwoPhase = twoPhase
+		s.TopN = maxResults
+		s.StartSource("a")
+		s.Append(corpus[:50]...)
+		s.StartSource("b")
+		s.Append(corpus[50:]...)
+
+		got := s.RankedResults(maxResults)
+		if len(got) != maxResults {
+			t.Fatalf("got %d results with two-phase %t, want %d", len(got), twoPhase, maxResults)
+		}
+		for i, r := range got {
+			if r.Input != corpus[i] || len(r.Highlights()) != 0 {
+				t.Fatalf("got result %d %q with highlights %v and two-phase %t, want %q unhighlighted", i, r.Input, r.Highlights(), twoPhase, corpus[i])
+			}
+		}
+	}
+}
+
+func TestField(t *testing.T) {
+	s := New("ab")
+	s.Field = 2
+	s.FieldSep = "::"
+	s.Then = "c"
+	for _, tt := range []struct {
+		input       string
+		ok          bool
+		spans, then []Span
+	}{
+		{"ab::xaxbc::c", true, []Span{{5, 6}, {7, 8}}, []Span{{8, 9}}},
+		{"ab::xaxb::c", false, nil, nil}, // Then only searches the field too
+		{"x::abc", true, []Span{{3, 5}}, []Span{{5, 6}}},
+		{"abc", false, nil, nil},
+		{"abc::x", false, nil, nil},
+	} {
+		r, ok := s.Match(tt.input)
+		if ok != tt.ok || fmt.Sprint(r.Matches) != fmt.Sprint(tt.spans) || fmt.Sprint(r.Extra) != fmt.Sprint(tt.then) {
+			t.Errorf("got %v, %v, %t for %q, want %v, %v, %t", r.Matches, r.Extra, ok, tt.input, tt.spans, tt.then, tt.ok)
+		}
+		if ok && r.Input != tt.input {
+			t.Errorf("got input %q, want the whole input %q", r.Input, tt.input)
+		}
+	}
+}
+
+func TestLength(t *testing.T) {
+	s := New("a")
+	s.MinLen, s.MaxLen = 2, 3
+	s.Append("a", "ab", "äba", "abcd")
+	var got []string
+	for _, r := range s.RankedResults(maxResults) {
+		got = append(got, r.Input)
+	}
+	if want := []string{"ab", "äba"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("got results %q, want %q", got, want)
+	}
+}
+
+// reverseScorer ranks results in the opposite order to ByRank, and gives the
+// inputs it favors a bonus.
+type reverseScorer struct{ favorite string }
+
+func (reverseScorer) Less(a, b Result) bool {
+	return ByRank([]Result{b, a}).Less(0, 1)
+}
+
+func (r reverseScorer) Bonus(res Result) float64 {
+	if res.Input == r.favorite {
+		return 10
+	}
+	return 0
+}
+
+func TestScorer(t *testing.T) {
+	inputs := []string{"abc", "axbxc", "ab", "xabc"}
+	s := New("abc")
+	s.Append(inputs...)
+	want := s.RankedResults(maxResults)
+
+	s = New("abc")
+	s.Scorer = reverseScorer{}
+	s.Append(inputs...)
+	got := s.RankedResults(maxResults)
+	if len(got) != len(want) {
+		t.Fatalf("got %d results, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if w := want[len(want)-1-i]; got[i].Input != w.Input {
+			t.Errorf("got result %d %q, want %q", i, got[i].Input, w.Input)
+		}
+	}
+
+	s = New("abc")
+	s.Scorer = reverseScorer{favorite: "ab"}
+	s.Append(inputs...)
+	if got := s.RankedResults(maxResults); got[len(got)-1].Input != "ab" {
+		t.Errorf("got last result %q, want ab with its bonus", got[len(got)-1].Input)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	s := New("ply")
+	s.Weights = map[string]int{"people": 3, "unused": 10}
+	s.Append("ply", "people", "apply")
+	got := s.RankedResults(maxResults)
+	var inputs []string
+	for _, r := range got {
+		inputs = append(inputs, r.Input)
+	}
+	// people only matches two runes, but its weight outranks ply's full
+	// match. The unweighted inputs keep their usual order.
+	if want := []string{"people", "ply", "apply"}; strings.Join(inputs, ",") != strings.Join(want, ",") {
+		t.Errorf("got results %q, want %q", inputs, want)
+	}
+	if got[1].Bonus != 1 {
+		t.Errorf("got bonus %v for unweighted ply, want only the substring bonus of 1", got[1].Bonus)
+	}
+}
+
+func TestCaseBonusTokens(t *testing.T) {
+	// AB CD matches the case of the term's second token and ab cd the
+	// case of its first, so they're worth the same.
+	spans := []Span{{0, 2}, {3, 5}}
+	upper := caseBonus(Result{Input: "AB CD", Matches: spans}, "ab CD", Options{})
+	lower := caseBonus(Result{Input: "ab cd", Matches: spans}, "ab CD", Options{})
+	if upper != lower || upper != 0.25 {
+		t.Errorf("got case bonuses %v and %v, want 0.25 for both", upper, lower)
+	}
+}
+
+func TestCaseBonusMarks(t *testing.T) {
+	// The combining acute accent is folded into the e before it, so the C
+	// after it still lines up with the term's C.
+	input := "e\u0301Cole"
+	r := Result{Input: input, Matches: []Span{{0, len(input)}}}
+	if got := caseBonus(r, "eCole", Options{FoldDiacritics: true}); got != 0.5 {
+		t.Errorf("got case bonus %v, want the full 0.5", got)
+	}
+
+	s := New("ÉCOLE")
+	s.Opts.FoldCase = true
+	s.Opts.FoldDiacritics = true
+	s.CaseRank = true
+	s.Append("école", "E\u0301COLE")
+	got := s.RankedResults(maxResults)
+	if len(got) != 2 || got[0].Input != "E\u0301COLE" {
+		t.Errorf("got results %v, want the uppercase match first", got)
+	}
+}
+
+func TestSubstringBonusRunes(t *testing.T) {
+	// The precomposed é is two bytes, but matches the one-byte e in cafe.
+	s := New("café")
+	s.Opts.FoldDiacritics = true
+	s.Append("cafe", "c-a-f-e")
+	got := s.RankedResults(maxResults)
+	if len(got) != 2 || got[0].Input != "cafe" || got[0].Bonus != 1 {
+		t.Fatalf("got results %v, want cafe first with the substring bonus of 1", got)
+	}
+	if got[1].Bonus != 0 {
+		t.Errorf("got bonus %v for c-a-f-e, want no substring bonus", got[1].Bonus)
+	}
+}
+
+func TestFoldedCaseRunes(t *testing.T) {
+	// ẞ and the Kelvin sign are three bytes, but each folds to a single
+	// rune of the term, so they mustn't outscore the exact match.
+	for _, tt := range []struct{ term, folded string }{
+		{"ß", "ẞ"},
+		{"K", "K"},
+	} {
+		s := New(tt.term)
+		s.Opts.FoldCase = true
+		s.Append(tt.folded, tt.term)
+		got := s.RankedResults(maxResults)
+		if len(got) != 2 || got[0].MatchScore() != 1 || got[1].MatchScore() != 1 {
+			t.Errorf("got results %v for %q with -i, want both to score 1", got, tt.term)
+		}
+
+		s = New(tt.term)
+		s.Opts.FoldCase = true
+		s.CaseRank = true
+		s.Append(tt.folded, tt.term)
+		got = s.RankedResults(maxResults)
+		if len(got) != 2 || got[0].Input != tt.term {
+			t.Errorf("got results %v for %q with -case-rank, want the exact match first", got, tt.term)
+		}
+	}
+}
+
+func TestJobs(t *testing.T) {
+	s := New("a")
+	s.Jobs = 1
+	s.BatchBytes = 4
+	s.Append("aaaa", "xa", "ax", "a")
+	if got := s.Batches(); got != 2 {
+		t.Errorf("got %d batches, want 2", got)
+	}
+	if got := cap(s.batchSem); got != 1 {
+		t.Errorf("got %d concurrent jobs, want 1", got)
+	}
+	if got := s.RankedResults(maxResults); len(got) != 4 {
+		t.Errorf("got %d results, want 4", len(got))
+	}
+}
+
+// pathCorpus returns n file paths generated from a fixed seed.
+func pathCorpus(n int) []string {
+	words := []string{"src", "cmd", "main", "internal", "server", "client", "config", "util", "test", "api", "model", "view", "handler", "router", "cache"}
+	exts := []string{".go", ".md", ".json", ".yaml", "_test.go"}
+	rng := rand.New(rand.NewSource(1))
+	corpus := make([]string, n)
+	for i := range corpus {
+		var path []string
+		for d := rng.Intn(5) + 1; d > 0; d-- {
+			path = append(path, words[rng.Intn(len(words))])
+		}
+		corpus[i] = "./" + strings.Join(path, "/") + exts[rng.Intn(len(exts))]
+	}
+	return corpus
+}
+
+func TestTwoPhase(t *testing.T) {
+	corpus := pathCorpus(20000)
+	for _, term := range []string{"main", "srvcfg", "hndtst", "api.json", "cmdmain.go"} {
+		ranked := func(twoPhase bool) []string {
+			s := New(term)
+			s.BatchBytes = 50000
+			if twoPhase {
+				s.TwoPhase = true
+				s.TopN = twoPhaseCandidates * maxResults
+			}
+			for _, c := range corpus {
+				s.Append(c)
+			}
+
+			// Compare ranks rather than inputs, since equally ranked
+			// inputs can come back in any order.
+			var ranks []string
+			for _, r := range s.RankedResults(maxResults) {
+				ranks = append(ranks, fmt.Sprint(r.Score(), r.GapScore(), len(r.Input)))
+			}
+			return ranks
+		}
+
+		want := ranked(false)
+		if got := ranked(true); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got two-phase ranks for %q:\n%v\nwant:\n%v", term, got, want)
+		}
+	}
+}
+
+// unixCorpus returns n paths laid out like a Unix filesystem, where most of the
+// inputs that a term like lib aligns with greedily are deep in directories
+// that contain it, ahead of the short paths that fully rank best.
+func unixCorpus(n int) []string {
+	dirs := []string{"/usr/lib/x86_64-linux-gnu", "/usr/lib/python3/dist-packages", "/usr/share/doc", "/usr/share/locale/de/LC_MESSAGES", "/usr/include/linux", "/usr/share/man/man1", "/usr/local/go/src", "/usr/bin", "/etc/default"}
+	names := []string{"libc", "libssl", "zlib", "readme", "changelog", "config", "locale", "python", "gettext", "bash", "core", "util"}
+	exts := []string{"", ".so.6", ".gz", ".h", ".py", ".conf", ".mo"}
+	rng := rand.New(rand.NewSource(1))
+	corpus := []string{"/usr/local/lib", "/usr/local/go/lib", "/usr/share/gdb/auto-load/lib", "/usr/local/etc/conf"}
+	for len(corpus) < n {
+		corpus = append(corpus, dirs[rng.Intn(len(dirs))]+"/"+names[rng.Intn(len(names))]+exts[rng.Intn(len(exts))])
+	}
+	return corpus
+}
+
+func TestTwoPhaseUnixPaths(t *testing.T) {
+	corpus := unixCorpus(20000)
+	for _, term := range []string{"lib", "conf", "loclib", "sharedoc"} {
+		ranked := func(twoPhase bool) []string {
+			s := New(term)
+			s.BatchBytes = 50000
+			if twoPhase {
+				s.TwoPhase = true
+				s.TopN = twoPhaseCandidates * maxResults
+			}
+			s.Append(corpus...)
+			var inputs []string
+			for _, r := range s.RankedResults(maxResults) {
+				inputs = append(inputs, r.Input)
+			}
+			return inputs
+		}
+
+		want := ranked(false)
+		if got := ranked(true); strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("got two-phase results for %q:\n%v\nwant:\n%v", term, got, want)
+		}
+	}
+}
+
+func TestParallelMerge(t *testing.T) {
+	var corpus []string
+	for i := 0; i < 200; i++ {
+		corpus = append(corpus, strings.Repeat("x", i%37)+"m"+strings.Repeat("x", i%11)+"oo"+strings.Repeat("y", 50*i))
+	}
+	ranked := func(topN int) []string {
+		s := New("moo")
+		s.BatchBytes = 20000
+		s.TopN = topN
+		for _, c := range corpus {
+			s.Append(c)
+		}
+		if s.batchCount < 2 {
+			t.Fatalf("got %d batches, want enough input to batch", s.batchCount)
+		}
+		var inputs []string
+		for _, r := range s.RankedResults(maxResults) {
+			inputs = append(inputs, r.Input)
+		}
+		return inputs
+	}
+
+	want := ranked(0)
+	got := ranked(maxResults)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got merged results:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestRankedResultsContext(t *testing.T) {
+	rank := func(s *Searcher, ctx context.Context) {
+		t.Helper()
+		errc := make(chan error, 1)
+		go func() {
+			_, err := s.RankedResultsContext(ctx, maxResults)
+			errc <- err
+		}()
+		select {
+		case err := <-errc:
+			if err != context.Canceled {
+				t.Errorf("got error %v, want %v", err, context.Canceled)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("got no results after cancelling, want an early return")
+		}
+	}
+
+	// Cancelling while the unbatched inputs are being matched stops
+	// matching them.
+	s := New("moo")
+	s.BatchBytes = 1 << 30
+	s.Append(corpus.Pathological(1000, 10000)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	rank(s, ctx)
+
+	// Batches waiting to deliver their results are released.
+	s = New("moo")
+	s.BatchBytes = 1000
+	s.Append(corpus.Pathological(200, 1000)...)
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	rank(s, ctx)
+}
+
+func TestStreamResults(t *testing.T) {
+	corpus := pathCorpus(2000)
+	batched := New("srvgo")
+	batched.BatchBytes = 1000
+	batched.Append(corpus...)
+	want := batched.RankedResults(maxResults)
+
+	s := New("srvgo")
+	s.BatchBytes = 1000
+	inputs := make(chan string)
+	reported := make(chan struct{})
+	go func() {
+		// The second half of the corpus isn't sent until results from
+		// the first half have been reported.
+		for _, c := range corpus[:len(corpus)/2] {
+			inputs <- c
+		}
+		select {
+		case <-reported:
+		case <-time.After(5 * time.Second):
+			t.Error("got no partial results before all of the inputs were sent")
+		}
+		for _, c := range corpus[len(corpus)/2:] {
+			inputs <- c
+		}
+		close(inputs)
+	}()
+
+	var partial [][]Result
+	got, err := s.StreamResults(context.Background(), inputs, maxResults, func(results []Result) {
+		if len(partial) == 0 {
+			close(reported)
+		}
+		partial = append(partial, results)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) == 0 {
+		t.Fatal("got no partial results")
+	}
+	if len(partial[0]) == 0 || len(partial[0]) > maxResults {
+		t.Errorf("got %d results in the first partial results, want from 1 to %d", len(partial[0]), maxResults)
+	}
+	if got, want := fmt.Sprint(got), fmt.Sprint(want); got != want {
+		t.Errorf("got streamed results %s, want the batched results %s", got, want)
+	}
+}
+
+func TestReset(t *testing.T) {
+	s := New("moo")
+	s.BatchBytes = 64
+	s.Unique = true
+	search := func(corpus []string) []string {
+		t.Helper()
+		s.Append(corpus...)
+		var inputs []string
+		for _, r := range s.RankedResults(0) {
+			inputs = append(inputs, r.Input)
+		}
+		if s.Matched() != len(inputs) || s.Total() != len(corpus) {
+			t.Errorf("got %d matched of %d, want %d of %d", s.Matched(), s.Total(), len(inputs), len(corpus))
+		}
+		sort.Strings(inputs)
+		s.Reset()
+		return inputs
+	}
+
+	var first, second []string
+	for i := 0; i < 50; i++ {
+		first = append(first, fmt.Sprintf("moo%02d", i), "dog")
+		second = append(second, fmt.Sprintf("xmoo%02d", i), "cat")
+	}
+	if got := search(first); len(got) != 50 || got[0] != "moo00" {
+		t.Errorf("got %d results starting with %q, want 50 starting with moo00", len(got), got)
+	}
+	if got := search(second); len(got) != 50 || got[0] != "xmoo00" {
+		t.Errorf("got %d results starting with %q after a reset, want 50 starting with xmoo00", len(got), got)
+	}
+
+	// A cancelled search doesn't leak into the next one.
+	s.Append(corpus.Pathological(200, 1000)...)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := s.RankedResultsContext(ctx, maxResults); err != context.Canceled {
+		t.Fatalf("got error %v, want %v", err, context.Canceled)
+	}
+	s.Reset()
+	if got := search(first); len(got) != 50 {
+		t.Errorf("got %d results after a cancelled search, want 50", len(got))
+	}
+}
+
+func TestForEachResult(t *testing.T) {
+	s := New("srvcfg")
+	s.BatchBytes = 50000
+	corpus := pathCorpus(5000)
+	for _, max := range []int{maxResults, 0} {
+		s.Reset()
+		s.Append(corpus...)
+		want := s.RankedResults(max)
+
+		s.Reset()
+		s.Append(corpus...)
+		var got []Result
+		s.ForEachResult(max, func(r Result) bool {
+			got = append(got, r)
+			return len(got) < 10
+		})
+		if len(got) != 10 {
+			t.Fatalf("got %d results with max %d, want iteration to stop after 10", len(got), max)
+		}
+
+		s.Reset()
+		s.Append(corpus...)
+		got = got[:0]
+		s.ForEachResult(max, func(r Result) bool {
+			got = append(got, r)
+			return true
+		})
+		if len(got) != len(want) {
+			t.Fatalf("got %d results with max %d, want %d", len(got), max, len(want))
+		}
+		for i, r := range got {
+			if r.Input != want[i].Input {
+				t.Errorf("got result %d %q with max %d, want %q", i, r.Input, max, want[i].Input)
+			}
+		}
+	}
+}
+
+func TestTopResults(t *testing.T) {
+	top := topResults{max: 2}
+	for _, in := range []string{"xxxab", "ab", "xab", "a", "xxab"} {
+		res, _ := bestMatch(in, "ab", Options{})
+		top.add(res)
+	}
+	sort.Sort(ByRank(top.results))
+	if len(top.results) != 2 || top.results[0].Input != "ab" || top.results[1].Input != "xab" {
+		t.Errorf("got top results %v, want ab and xab", top.results)
+	}
+}
+
+func benchmarkPathologicalFind(b *testing.B, n, m int) {
+	benchmarkPathological(b, n, m, func(s *Searcher) {})
+}
+
+func benchmarkPathologicalMerge(b *testing.B, n, m int) {
+	benchmarkPathological(b, n, m, func(s *Searcher) { s.TopN = maxResults })
+}
+
+func benchmarkPathologicalTwoPhase(b *testing.B, n, m int) {
+	benchmarkPathological(b, n, m, func(s *Searcher) {
+		s.TwoPhase = true
+		s.TopN = twoPhaseCandidates * maxResults
+	})
+}
+
+func benchmarkPathological(b *testing.B, n, m int, setup func(*Searcher)) {
+	inputs := corpus.Pathological(n, m)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		searcher := New("moo")
+		setup(searcher)
+		for _, s := range inputs {
+			searcher.Append(s)
+		}
+		b.ReportMetric(float64(searcher.batchCount/b.N), "jobs/op")
+		searcher.RankedResults(maxResults)
+	}
+}
+
+// benchmarkPathologicalRank measures the allocations made by ranking the
+// results of a pathological search, where every input matches. Ranking
+// without a limit holds every result until they're sorted, so it shows the
+// cost that a limit's bounded heap avoids.
+func benchmarkPathologicalRank(b *testing.B, n, m, max int) {
+	inputs := corpus.Pathological(n, m)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s := New("moo")
+		s.Append(inputs...)
+		s.RankedResults(max)
+	}
+}
+
+func BenchmarkPathologicalRankAll1000000(b *testing.B) {
+	benchmarkPathologicalRank(b, 1000000, 100, 0)
+}
+
+func BenchmarkPathologicalRankTop1000000(b *testing.B) {
+	benchmarkPathologicalRank(b, 1000000, 100, maxResults)
+}
+
+func BenchmarkPathologicalFind1000(b *testing.B)    { benchmarkPathologicalFind(b, 1000, 100) }
+func BenchmarkPathologicalFind5000(b *testing.B)    { benchmarkPathologicalFind(b, 5000, 100) }
+func BenchmarkPathologicalFind10000(b *testing.B)   { benchmarkPathologicalFind(b, 10000, 100) }
+func BenchmarkPathologicalFind50000(b *testing.B)   { benchmarkPathologicalFind(b, 50000, 100) }
+func BenchmarkPathologicalFind100000(b *testing.B)  { benchmarkPathologicalFind(b, 100000, 100) }
+func BenchmarkPathologicalFind500000(b *testing.B)  { benchmarkPathologicalFind(b, 500000, 100) }
+func BenchmarkPathologicalFind1000000(b *testing.B) { benchmarkPathologicalFind(b, 1000000, 100) }
+
+func BenchmarkPathologicalMerge1000(b *testing.B)    { benchmarkPathologicalMerge(b, 1000, 100) }
+func BenchmarkPathologicalMerge5000(b *testing.B)    { benchmarkPathologicalMerge(b, 5000, 100) }
+func BenchmarkPathologicalMerge10000(b *testing.B)   { benchmarkPathologicalMerge(b, 10000, 100) }
+func BenchmarkPathologicalMerge50000(b *testing.B)   { benchmarkPathologicalMerge(b, 50000, 100) }
+func BenchmarkPathologicalMerge100000(b *testing.B)  { benchmarkPathologicalMerge(b, 100000, 100) }
+func BenchmarkPathologicalMerge500000(b *testing.B)  { benchmarkPathologicalMerge(b, 500000, 100) }
+func BenchmarkPathologicalMerge1000000(b *testing.B) { benchmarkPathologicalMerge(b, 1000000, 100) }
+
+func BenchmarkPathologicalTwoPhase1000(b *testing.B)   { benchmarkPathologicalTwoPhase(b, 1000, 100) }
+func BenchmarkPathologicalTwoPhase5000(b *testing.B)   { benchmarkPathologicalTwoPhase(b, 5000, 100) }
+func BenchmarkPathologicalTwoPhase10000(b *testing.B)  { benchmarkPathologicalTwoPhase(b, 10000, 100) }
+func BenchmarkPathologicalTwoPhase50000(b *testing.B)  { benchmarkPathologicalTwoPhase(b, 50000, 100) }
+func BenchmarkPathologicalTwoPhase100000(b *testing.B) { benchmarkPathologicalTwoPhase(b, 100000, 100) }
+func BenchmarkPathologicalTwoPhase500000(b *testing.B) { benchmarkPathologicalTwoPhase(b, 500000, 100) }
+func BenchmarkPathologicalTwoPhase1000000(b *testing.B) {
+	benchmarkPathologicalTwoPhase(b, 1000000, 100)
+}
@@ -0,0 +1,16 @@
+// Package corpus builds inputs for measuring fz's search throughput, shared by
+// the -benchmark option and the fuzzy package's tests and benchmarks.
+package corpus
+
+import "strings"
+
+// Pathological returns n inputs of m bytes each that are slow for search to
+// match against "moo". Every byte except the last two matches the term's first
+// rune, so search finds an alignment starting at each of them.
+func Pathological(n, m int) []string {
+	corpus := make([]string, n)
+	for i := 0; i < len(corpus); i++ {
+		corpus[i] = strings.Repeat("m", m-2) + "oo"
+	}
+	return corpus
+}
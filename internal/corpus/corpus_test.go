@@ -0,0 +1,15 @@
+package corpus
+
+import "testing"
+
+func TestPathological(t *testing.T) {
+	corpus := Pathological(3, 5)
+	if len(corpus) != 3 {
+		t.Fatalf("got %d inputs, want 3", len(corpus))
+	}
+	for _, c := range corpus {
+		if c != "mmmoo" {
+			t.Errorf("got input %q, want mmmoo", c)
+		}
+	}
+}
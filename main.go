@@ -3,21 +3,60 @@ package main
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
 	"io"
 	"os"
+	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/gcurtis/fz/fuzzy"
+	"github.com/gcurtis/fz/internal/corpus"
 )
 
-// maxResults limits the results to the top N matches.
+// maxResults is the default limit on the number of results.
 const maxResults = 25
 
-func printUsage(w io.StringWriter) {
-	w.WriteString(`usage: fz <search>
+// matchNothing is a regular expression that never matches.
+var matchNothing = regexp.MustCompile(`[^\x00-\x{10FFFF}]`)
+
+// twoPhaseCandidates is how many candidates for each result a two-phase search
+// keeps from its first phase. The first phase only finds an upper bound on each
+// candidate's score, and many candidates are tied on it, so it needs plenty of
+// them before the full ranking can tell which are the best.
+const twoPhaseCandidates = 20
+
+// maxTermLength is the most runes a search can have. Matching costs grow with
+// the length of the search, and nothing typed by hand comes close to it.
+const maxTermLength = 1024
+
+func printUsage(flags *flag.FlagSet) {
+	w := flags.Output()
+	io.WriteString(w, `usage: fz [options] <search> [file ...]
+       fz [options] -q <search> [file ...]
+       fz [options] -interactive [<search> [file ...]]
 
 fz performs a fuzzy prefix search against a line-delimited list of strings read
-from stdin.
+from the given files, or from stdin if there aren't any. Stdin is also ignored
+when a file is given with -f. Inputs compressed with gzip are decompressed,
+and bytes in them that aren't valid UTF-8 are replaced with U+FFFD.
+Options may also follow the search and files, unless they're separated from
+them by "--".
+
+A search containing spaces only matches inputs that match each of its words,
+in any order.
+An empty search matches every input, and prints them in their original order.
 
 Examples:
 
@@ -38,243 +77,1348 @@ Examples:
 	place
 	people
 	person
+
+	# search a file, taking the search from -q instead of the first argument
+	$ fz -q pl words.txt
+
+	# prefix results with their file and line number like grep -Hn, where
+	# -line-number has no short form since -n limits the results
+	$ fz -with-filename -line-number os main.go
+	main.go:6:os.Exit(1)
+	main.go:3:import "os"
+
+	# narrow the results of one search with a second, independent search
+	$ find . | fz -then test .go
+	./main_test.go
+	./templates/index.gohtml
+
+	# leave out any results that also match a second search
+	$ find . | fz .go -v test
+	./main.go
+	./go.mod
+
+	# measure search throughput on this machine
+	$ fz -benchmark -bench-lines 50000
+
+	# pick a file interactively, refining the search as you type
+	$ vi "$(find . | fz -interactive)"
+
+	# load a corpus once and search it repeatedly from other processes
+	$ fz -serve /tmp/fz.sock words.txt &
+	$ fz -connect /tmp/fz.sock -query pl
+
+Options:
+
 `)
+	flags.PrintDefaults()
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		printUsage(os.Stderr)
-		os.Exit(1)
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes fz with the given command line arguments (excluding the
+// program name) and returns the process exit code.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fz", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {}
+	then := flags.String("then", "", "only keep results that also match every character of each word of a second search `term`")
+	bucket := flags.Bool("bucket", false, "group results under exact, strong and weak headers")
+	preserveANSI := flags.Bool("preserve-ansi", false, "ignore ANSI escape sequences in the input when searching, but keep them in the output")
+	lineNumbers := flags.Bool("line-number", false, "prefix each result with its line number in the input, like grep -n does, though fz's -n is the result limit")
+	decode := flags.String("decode", "", "decode each input from `base64|hex` before searching it")
+	showDecoded := flags.Bool("show-decoded", false, "print the decoded form of each result when using -decode")
+	strictOrder := flags.Bool("strict-order", false, "reject matches where consecutive matched characters are further apart than -window")
+	window := flags.Int("window", 8, "maximum `bytes` between consecutive matched characters with -strict-order")
+	rgJSON := flags.Bool("rg-json", false, "print results as ripgrep --json match records")
+	cacheDir := flags.String("cache-dir", "", "cache the parsed lines of the input in `dir`, keyed by a hash of its contents, so repeated searches of unchanged input skip parsing it")
+	parallelMerge := flags.Bool("parallel-merge", false, "only return each batch's best results to be merged, rather than all of them")
+	ngram := flags.Int("ngram", 0, "match overlapping n-grams of `k` characters from the search instead of single characters")
+	stats := flags.Bool("stats", false, "print how many lines were read and matched, how many batches they were matched in and how long it took to stderr")
+	footer := flags.Bool("footer", false, "print how many results were printed out of the total number of matches to stderr")
+	serve := flags.String("serve", "", "load the corpus from a file argument and answer queries on the unix `socket`")
+	connect := flags.String("connect", "", "send the search to a server listening on the unix `socket`")
+	query := flags.String("query", "", "the search `term`, instead of the first argument")
+	flags.StringVar(query, "q", "", "shorthand for -query, the search `term`")
+	countWeight := flags.Float64("count-weight", 0, "rank inputs containing more alignments of the search higher, each extra one being worth `w` matched characters")
+	leet := flags.Bool("leet", false, "treat leet-speak substitutions like 0 for o and 3 for e as matching the letters they replace")
+	withFilename := flags.Bool("with-filename", false, "prefix each result with the name of the file it was read from")
+	maxPerSource := flags.Int("max-per-source", 0, "limit the results from any one file to `k`")
+	echoOnEmpty := flags.Bool("echo-on-empty", false, "print the search and how many lines were read to stderr when nothing matches")
+	positional := flags.Bool("positional", false, "match each space-separated word of the search in order, in separate parts of the input")
+	weightsFile := flags.String("weights", "", "boost the score of inputs listed in `file`, one per line as the exact input, a tab and an integer boost")
+	perTierLimit := flags.Int("per-tier-limit", 0, "limit the results that share the same score to `k`")
+	benchmark := flags.Bool("benchmark", false, "time a search of a generated corpus and print metrics about it")
+	benchLines := flags.Int("bench-lines", 100000, "number of `lines` to generate with -benchmark")
+	benchLength := flags.Int("bench-length", 100, "length in `bytes` of each generated line with -benchmark")
+	caseRank := flags.Bool("case-rank", false, "match regardless of case, but rank results matching the search's case higher")
+	prefixRunes := flags.Int("prefix-runes", 0, "require the first `k` characters of the search to match together at the start of a word")
+	withID := flags.Bool("with-id", false, "prefix each result with a stable id derived from its input and a tab")
+	twoPhase := flags.Bool("two-phase", false, "quickly narrow large inputs down to the best candidates before fully ranking them")
+	fromMatch := flags.Bool("from-match", false, "only print each result from its first matched character onwards")
+	ignoreCase := flags.Bool("i", false, "match regardless of case")
+	smartCase := flags.Bool("smart-case", false, "match regardless of case unless the search contains uppercase")
+	limit := flags.Int("n", maxResults, "limit the results to the best `count`, or 0 for no limit")
+	reverse := flags.Bool("reverse", false, "print results from worst to best, so the best is last")
+	best := flags.Bool("1", false, "print only the best result, and exit with status 1 if nothing matches")
+	color := flags.String("color", "auto", "highlight matches `always|auto|never`, where auto only highlights them when writing to a terminal")
+	hlColor := flags.String("hl-color", "1", "highlight matches with the SGR graphics `code`, such as 1 for bold or 32 for green, or fg=5;N for 256-color and fg=2;R;G;B for truecolor, with bg= for backgrounds")
+	hlStyle := flags.String("hl-style", "bold", "highlight matches in the `style` bold, underline, reverse or none, in addition to any -hl-color")
+	format := flags.String("format", "text", "print results as `text|html|json|jsonl`, where html wraps each in a div and marks its matches, and json and jsonl write a JSON array of objects or one object per line")
+	showScore := flags.Bool("score", false, "prefix each result with its match and gap scores and the fraction of the search it matched, such as [5,-1,0.83]")
+	var null bool
+	flags.BoolVar(&null, "0", false, "read inputs separated by NUL bytes rather than newlines, and separate results with them too")
+	flags.BoolVar(&null, "read0", false, "same as -0")
+	noTrailing := flags.Bool("no-trailing-newline", false, "don't write a newline, or the delimiter set by -0 or -d, after the final result")
+	delimiter := flags.String("d", "", "read inputs separated by the single byte `delim`, which may be an escape like \\t, rather than newlines, and separate results with it too")
+	var inputFiles fileList
+	flags.Var(&inputFiles, "f", "read inputs from `file`, ignoring stdin, in addition to any file arguments. It can be given more than once")
+	exclude := flags.String("v", "", "drop inputs that match every character of the `term`")
+	pathRank := flags.Bool("path", false, "rank results matching in the last segment of a path, after its final slash, higher")
+	useRegexp := flags.Bool("e", false, "treat the search as a Go regular `expression`, ranking results by how much of them it matches")
+	substr := flags.Bool("substr", false, "only match inputs containing the search as a contiguous substring, without gaps")
+	positions := flags.Bool("positions", false, "follow each result with a tab and the comma-separated character offsets of its matches, instead of highlighting them")
+	noTrim := flags.Bool("no-trim", false, "search and print inputs exactly as they're read, without trimming their leading and trailing whitespace")
+	unique := flags.Bool("u", false, "only keep the first of any identical inputs")
+	minLen := flags.Int("min-len", 0, "skip inputs shorter than `n` characters")
+	maxLen := flags.Int("max-len", 0, "skip inputs longer than `n` characters")
+	count := flags.Bool("c", false, "only print the number of inputs that match, regardless of the result limit")
+	interactive := flags.Bool("interactive", false, "load the inputs and pick one of them in a full-screen search that starts from the search, if one is given, and is refined as you type, then print it")
+	nonMatching := flags.Bool("non-matching", false, "print the inputs that don't match the search instead, in their original order")
+	allSpans := flags.Bool("all-spans", false, "highlight every match of the whole search in each result, not just the best one")
+	prefer := flags.String("prefer", "short", "break ties between equally ranked results in `way` short, preferring shorter inputs, or shallow, preferring inputs with fewer slashes")
+	sortMode := flags.String("sort", "score", "sort results in `mode` score, by how well they match, length, shortest first, or input, lexicographically")
+	foldDiacritics := flags.Bool("fold-diacritics", false, "match Latin-1 and Latin Extended-A letters regardless of their diacritics, so that e matches é, along with letters followed by combining marks")
+	jobs := flags.Int("jobs", runtime.NumCPU(), "match at most `n` batches of inputs concurrently")
+	batchBytes := flags.Int("batch-bytes", 256000, "collect `n` bytes of inputs into each batch before matching it")
+	width := flags.Int("width", 0, "truncate each result to `n` columns, or 0 to never truncate them (default the width of the terminal being written to)")
+	contextRunes := flags.Int("context", 0, "print only `n` characters on each side of a result's highlights, replacing the rest of it with …")
+	expandTabs := flags.Int("expand-tabs", 0, "print each tab in a result as `n` spaces, so that highlighting doesn't misalign columns")
+	field := flags.Int("field", 0, "only search the `n`th field of each input, counting from 1, while still printing the whole input")
+	fieldSep := flags.String("field-sep", "\t", "separate the fields of -field with `sep`, which may contain escapes like \\t")
+	bucketBounds := flags.String("bucket-bounds", "1,0.5", "minimum fraction of the search matched for the exact and strong `tiers`")
+	operands, err := parseArgs(flags, args)
+	if err == flag.ErrHelp {
+		flags.SetOutput(stdout)
+		printUsage(flags)
+		return 0
+	} else if err != nil {
+		printUsage(flags)
+		return 2
+	}
+	start := time.Now()
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	bounds, err := parseBucketBounds(*bucketBounds)
+	if err != nil {
+		fmt.Fprintln(stderr, "fz:", err)
+		return 2
+	}
+	decoder, err := newDecoder(*decode)
+	if err != nil {
+		fmt.Fprintln(stderr, "fz:", err)
+		return 2
+	}
+	var weights map[string]int
+	if *weightsFile != "" {
+		weights, err = loadWeights(*weightsFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 2
+		}
+	}
+	if *prefixRunes < 0 {
+		fmt.Fprintln(stderr, "fz: prefix runes must not be negative")
+		return 2
+	}
+	opts := fuzzy.Options{
+		Ngram:          *ngram,
+		Leet:           *leet,
+		FoldCase:       *ignoreCase || *caseRank,
+		PrefixRunes:    *prefixRunes,
+		Positional:     *positional,
+		Substring:      *substr,
+		FoldDiacritics: *foldDiacritics,
+	}
+	if *strictOrder {
+		if *window < 1 {
+			fmt.Fprintln(stderr, "fz: window must be positive")
+			return 2
+		}
+		opts.Window = *window
+	}
+	if *ngram < 0 {
+		fmt.Fprintln(stderr, "fz: ngram must not be negative")
+		return 2
+	}
+	if *maxPerSource < 0 {
+		fmt.Fprintln(stderr, "fz: max per source must not be negative")
+		return 2
+	}
+	if *perTierLimit < 0 {
+		fmt.Fprintln(stderr, "fz: per tier limit must not be negative")
+		return 2
+	}
+	if *color != "always" && *color != "auto" && *color != "never" {
+		fmt.Fprintf(stderr, "fz: unknown color mode %q\n", *color)
+		return 2
 	}
-	search := os.Args[1]
-	switch search {
-	case "-h", "-help", "--help":
-		printUsage(os.Stdout)
-		os.Exit(0)
+	switch *format {
+	case "text", "html", "json", "jsonl":
+	default:
+		fmt.Fprintf(stderr, "fz: unknown format %q\n", *format)
+		return 2
+	}
+	if *positions && (*format != "text" || *rgJSON) {
+		fmt.Fprintln(stderr, "fz: -positions can't be combined with -format or -rg-json")
+		return 2
+	}
+	if *format != "text" && *rgJSON {
+		fmt.Fprintln(stderr, "fz: -format can't be combined with -rg-json")
+		return 2
+	}
+	if (*format == "json" || *format == "jsonl" || *rgJSON) && *bucket {
+		fmt.Fprintln(stderr, "fz: -bucket can't be combined with -format json or jsonl, or -rg-json")
+		return 2
+	}
+	delim := byte('\n')
+	if *delimiter != "" {
+		if null {
+			fmt.Fprintln(stderr, "fz: -d can't be combined with -0")
+			return 2
+		}
+		delim, err = parseDelimiter(*delimiter)
+		if err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 2
+		}
+	}
+	if null {
+		delim = 0
+	}
+	if delim != '\n' && (*cacheDir != "" || *serve != "") {
+		fmt.Fprintln(stderr, "fz: -0 and -d can't be combined with -cache-dir or -serve")
+		return 2
+	}
+	hlCode, err := parseHighlightColor(*hlColor)
+	if err != nil {
+		fmt.Fprintln(stderr, "fz:", err)
+		return 2
+	}
+	if *prefer != "short" && *prefer != "shallow" {
+		fmt.Fprintf(stderr, "fz: unknown preference %q\n", *prefer)
+		return 2
+	}
+	if _, ok := sortOrders[*sortMode]; !ok {
+		fmt.Fprintf(stderr, "fz: unknown sort mode %q\n", *sortMode)
+		return 2
+	}
+	if *sortMode != "score" && *perTierLimit > 0 {
+		fmt.Fprintln(stderr, "fz: -sort can't be combined with -per-tier-limit, since tiers are ranked by score")
+		return 2
+	}
+	if _, ok := highlightStyles[*hlStyle]; !ok {
+		fmt.Fprintf(stderr, "fz: unknown highlight style %q\n", *hlStyle)
+		return 2
+	}
+	if *minLen < 0 || *maxLen < 0 {
+		fmt.Fprintln(stderr, "fz: min-len and max-len must not be negative")
+		return 2
+	}
+	if *jobs < 1 || *batchBytes < 1 {
+		fmt.Fprintln(stderr, "fz: jobs and batch bytes must be positive")
+		return 2
+	}
+	if *width < 0 {
+		fmt.Fprintln(stderr, "fz: width must not be negative")
+		return 2
+	}
+	if !set["width"] {
+		*width = terminalWidth(stdout)
+	}
+	if *contextRunes < 0 {
+		fmt.Fprintln(stderr, "fz: context must not be negative")
+		return 2
+	}
+	if *expandTabs < 0 {
+		fmt.Fprintln(stderr, "fz: expand tabs must not be negative")
+		return 2
+	}
+	if *field < 0 {
+		fmt.Fprintln(stderr, "fz: field must not be negative")
+		return 2
+	}
+	sep, err := strconv.Unquote(`"` + *fieldSep + `"`)
+	if err != nil {
+		sep = *fieldSep
+	}
+	if sep == "" {
+		fmt.Fprintln(stderr, "fz: field separator must not be empty")
+		return 2
+	}
+	if *limit < 0 {
+		fmt.Fprintln(stderr, "fz: result limit must not be negative")
+		return 2
+	}
+	if *countWeight < 0 {
+		fmt.Fprintln(stderr, "fz: count weight must not be negative")
+		return 2
+	}
+	// A server only sends back its ranked inputs and how many matched, so
+	// there's no telling where they came from or how the search went.
+	if *connect != "" && (*stats || *lineNumbers || *withFilename) {
+		fmt.Fprintln(stderr, "fz: -connect can't be combined with -stats, -line-number or -with-filename")
+		return 2
+	}
+	if *interactive && (*serve != "" || *connect != "" || *benchmark || *count) {
+		fmt.Fprintln(stderr, "fz: -interactive can't be combined with -serve, -connect, -benchmark or -c")
+		return 2
 	}
 
-	s := newSearcher(search)
-	scanner := bufio.NewScanner(os.Stdin)
-	for scanner.Scan() {
-		s.append(scanner.Text())
+	if *useRegexp && (opts.Ngram > 0 || opts.Positional || *substr || *twoPhase || *foldDiacritics) {
+		fmt.Fprintln(stderr, "fz: -e can't be combined with -ngram, -positional, -substr, -two-phase or -fold-diacritics")
+		return 2
 	}
-	for _, r := range s.rankedResults(maxResults) {
-		r.printHighlight(os.Stdout)
+	if *foldDiacritics && opts.Ngram > 0 {
+		fmt.Fprintln(stderr, "fz: -fold-diacritics can't be combined with -ngram")
+		return 2
+	}
+	if *substr && (opts.Ngram > 0 || opts.Positional) {
+		fmt.Fprintln(stderr, "fz: -substr can't be combined with -ngram or -positional")
+		return 2
+	}
+	if *twoPhase && (opts.Ngram > 0 || opts.Positional) {
+		fmt.Fprintln(stderr, "fz: -two-phase can't be combined with -ngram or -positional")
+		return 2
+	}
+	if *allSpans && (opts.Ngram > 0 || opts.Positional) {
+		fmt.Fprintln(stderr, "fz: -all-spans can't be combined with -ngram or -positional")
+		return 2
 	}
-}
 
-type searcher struct {
-	term string
+	// configure returns a searcher for term that's asked for at most max
+	// results, or for all of them when max is 0.
+	configure := func(term string, max int) *fuzzy.Searcher {
+		// A search of only whitespace has no tokens to match, so it's
+		// treated like an empty search. A regular expression of
+		// whitespace still means what it says.
+		if !*useRegexp && strings.TrimSpace(term) == "" {
+			term = ""
+		}
+		s := fuzzy.New(term)
+		s.Opts = opts
+		if *smartCase && strings.IndexFunc(term, unicode.IsUpper) == -1 {
+			s.Opts.FoldCase = true
+		}
+		if *useRegexp {
+			if s.Opts.FoldCase {
+				term = "(?i)" + term
+			}
+			// Only the first search is checked before it's used, so
+			// expressions searched later by a server or interactively
+			// that don't compile match nothing.
+			re, err := regexp.Compile(term)
+			if err != nil {
+				re = matchNothing
+			}
+			s.Regexp = re
+		}
+		s.Then = *then
+		s.Exclude = *exclude
+		s.PreserveANSI = *preserveANSI
+		s.Decode = decoder
+		s.ShowDecoded = *showDecoded
+		s.CountWeight = *countWeight
+		s.Weights = weights
+		s.CaseRank = *caseRank
+		s.PathRank = *pathRank
+		s.MaxPerSource = *maxPerSource
+		s.PerTierLimit = *perTierLimit
+		s.Unique = *unique
+		s.MinLen, s.MaxLen = *minLen, *maxLen
+		s.PreserveSpace = *noTrim
+		s.Field = *field
+		s.Jobs = *jobs
+		s.BatchBytes = *batchBytes
+		s.Sort = sortOrders[*sortMode]
+		s.PreferShallow = *prefer == "shallow"
+		s.AllSpans = *allSpans
+		s.NonMatching = *nonMatching
+		s.FieldSep = sep
 
-	batch        []string
-	batchBytes   int
-	batchByteMin int
-	batchCount   int
-	batchSem     chan struct{}
-	batchResults chan []result
-}
+		// Capping results per source or tier happens after merging,
+		// so every batch's results are needed to fill the limit.
+		if *parallelMerge && *maxPerSource == 0 && *perTierLimit == 0 {
+			s.TopN = max
+		}
+		// Counting doesn't rank anything, so there's nothing for a
+		// first phase to narrow down.
+		if *twoPhase && !*count {
+			s.TwoPhase = true
+			// Without a limit, every candidate is fully ranked.
+			s.TopN = twoPhaseCandidates * max
+		}
+		return s
+	}
 
-func newSearcher(term string) searcher {
-	return searcher{
-		term:         term,
-		batchByteMin: 256000,
-		batchSem:     make(chan struct{}, runtime.NumCPU()),
-		batchResults: make(chan []result),
+	if *benchmark {
+		if *benchLines < 0 || *benchLength < 2 {
+			fmt.Fprintln(stderr, "fz: benchmark lines must not be negative and their length must be at least 2")
+			return 2
+		}
+		term := *query
+		if term == "" {
+			term = "moo"
+		}
+		runBenchmark(stdout, configure(term, *limit), corpus.Pathological(*benchLines, *benchLength), *limit)
+		return 0
+	}
+
+	if *serve != "" {
+		if len(inputFiles) > 1 {
+			fmt.Fprintln(stderr, "fz: -serve can only serve one file")
+			return 2
+		}
+		var corpus string
+		switch {
+		case len(inputFiles) == 1:
+			corpus = inputFiles[0]
+		case len(operands) < 1:
+			printUsage(flags)
+			return 1
+		default:
+			corpus = operands[0]
+		}
+		if err := listenAndServe(*serve, corpus, configure); err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 1
+		}
+		return 0
 	}
-}
 
-func (s *searcher) append(input ...string) {
-	for _, elem := range input {
-		elem = strings.TrimSpace(elem)
-		if elem == "" {
+	// Unless it's forced, highlighting is also disabled by setting NO_COLOR
+	// following the convention at https://no-color.org.
+	_, noColor := os.LookupEnv("NO_COLOR")
+	highlight := *color == "always" || *color == "auto" && !noColor && isTerminal(stdout)
+	open := highlightOpen(set, *hlStyle, hlCode)
+	if open == "" {
+		highlight = false
+	}
+	// The Windows console has to be told to interpret escapes. If it
+	// can't be, the highlights would be printed as garbage, so they're
+	// dropped unless they were forced.
+	if highlight && !enableEscapes(stdout) && *color != "always" {
+		highlight = false
+	}
+	if *best {
+		*limit = 1
+	}
+	split, eol := bufio.ScanLines, string(delim)
+	if delim != '\n' {
+		split = scanDelimited(delim)
+	}
+	if *noTrailing {
+		// JSON records always end with newlines, whatever the delimiter.
+		term := eol
+		if *format == "json" || *format == "jsonl" || *rgJSON {
+			term = "\n"
+		}
+		stdout = &untermWriter{w: stdout, term: term}
+	}
+	// write prints a result after a prefix that's already been printed
+	// in indent columns.
+	write := func(r fuzzy.Result, indent int) {
+		// Positions are offsets into the input as it was read, so its
+		// tabs are kept and it's never windowed or truncated.
+		truncated := false
+		if *contextRunes > 0 && !*positions {
+			r, truncated = trimContext(r, *contextRunes)
+		}
+		if *expandTabs > 0 && !*positions {
+			r = expandResultTabs(r, *expandTabs)
+		}
+		if *width > 0 && !*positions {
+			var cut bool
+			r, cut = truncate(r, *width-indent)
+			truncated = truncated || cut
+		}
+		switch {
+		case *positions:
+			// The positions count runes of the input without its
+			// own escapes, so they're left out.
+			io.WriteString(stdout, r.Input)
+			io.WriteString(stdout, "\t"+matchPositions(r))
+		case highlight:
+			printHighlight(stdout, r, open)
+		default:
+			// NO_COLOR asks for no color at all, not only for no
+			// highlights, so the input's own escapes go too.
+			if noColor && *color != "always" {
+				r.Escapes = nil
+			}
+			printPlain(stdout, r)
+		}
+		// Truncating can drop the reset that ended the input's own
+		// escapes.
+		if truncated && len(r.Escapes) > 0 {
+			io.WriteString(stdout, "\033[0m")
+		}
+		io.WriteString(stdout, eol)
+	}
+
+	// An empty -query is still a query, so that the first argument is always
+	// a file when one is given. An interactive search can start without
+	// one, since it's typed in as it goes.
+	term, files := *query, operands
+	if !set["query"] && !set["q"] {
+		switch {
+		case len(operands) > 0:
+			term, files = files[0], files[1:]
+		case !*interactive:
+			printUsage(flags)
+			return 1
+		}
+	}
+	files = append(append([]string(nil), inputFiles...), files...)
+
+	if n := utf8.RuneCountInString(term); n > maxTermLength {
+		fmt.Fprintf(stderr, "fz: search is %d characters long, more than the limit of %d\n", n, maxTermLength)
+		return 2
+	}
+	if *useRegexp {
+		if _, err := regexp.Compile(term); err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 2
+		}
+	}
+
+	s := configure(term, *limit)
+
+	print := func(r fuzzy.Result) {
+		if *fromMatch {
+			r = r.FromMatch()
+		}
+		if *rgJSON {
+			printRgJSON(stdout, r, *lineNumbers)
+			return
+		}
+		if *format == "jsonl" {
+			b, _ := json.Marshal(resultJSON(r, s.NormalizedScore(r)))
+			stdout.Write(append(b, '\n'))
+			return
+		}
+		var prefix strings.Builder
+		if *showScore {
+			fmt.Fprintf(&prefix, "[%d,%d,%.2f] ", r.MatchScore(), r.GapScore(), s.NormalizedScore(r))
+		}
+		if *withID {
+			fmt.Fprintf(&prefix, "%s\t", r.ID())
+		}
+		if *withFilename {
+			fmt.Fprintf(&prefix, "%s:", r.SourceName())
+		}
+		if *lineNumbers {
+			fmt.Fprintf(&prefix, "%d:", r.Line)
+		}
+		if *format == "html" {
+			printHTML(stdout, r, prefix.String())
+			io.WriteString(stdout, eol)
 			return
 		}
+		io.WriteString(stdout, prefix.String())
+		write(r, columns(prefix.String()))
+	}
 
-		s.batch = append(s.batch, elem)
-		s.batchBytes += len(elem)
-		if s.batchBytes >= s.batchByteMin {
-			s.batchSem <- struct{}{}
-			s.batchCount++
-			go func(batch []string) {
-				results := make([]result, len(batch))
-				for i, b := range batch {
-					elemResults := search(b, s.term, 0, nil)
-					if len(elemResults) == 0 {
-						continue
-					}
-					sort.Sort(byRank(elemResults))
-					results[i] = elemResults[0]
+	// output writes the results of a search, where matched and total are how
+	// many inputs matched and were searched.
+	output := func(results []fuzzy.Result, matched, total int) int {
+		if *stats {
+			// The elapsed time includes writing the results, so it's
+			// measured once they've all been written.
+			defer func() {
+				fmt.Fprintf(stderr, "fz: %d lines, %d matched, %d batches in %s\n", total, matched, s.Batches(), time.Since(start))
+			}()
+		}
+		if *footer {
+			defer fmt.Fprintf(stderr, "%d/%d matches\n", len(results), matched)
+		}
+		if *echoOnEmpty && len(results) == 0 {
+			fmt.Fprintf(stderr, "fz: no matches for %q in %d lines\n", term, total)
+		}
+		if *best && len(results) == 0 {
+			return 1
+		}
+		if *reverse {
+			for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+				results[i], results[j] = results[j], results[i]
+			}
+		}
+		if *bucket {
+			for _, b := range bucketResults(results, s, bounds) {
+				if *format == "html" {
+					io.WriteString(stdout, "<h2>"+b.name+"</h2>"+eol)
+				} else {
+					io.WriteString(stdout, b.name+":"+eol)
 				}
-				<-s.batchSem
-				s.batchResults <- results
-			}(s.batch)
-			s.batch = make([]string, 0, cap(s.batch))
-			s.batchBytes = 0
+				for _, r := range b.results {
+					print(r)
+				}
+			}
+			return 0
+		}
+		if *format == "json" {
+			all := make([]jsonResult, 0, len(results))
+			for _, r := range results {
+				if *fromMatch {
+					r = r.FromMatch()
+				}
+				all = append(all, resultJSON(r, s.NormalizedScore(r)))
+			}
+			b, _ := json.Marshal(all)
+			stdout.Write(append(b, '\n'))
+			return 0
+		}
+		for _, r := range results {
+			print(r)
 		}
+		return 0
 	}
+
+	if *connect != "" {
+		rep, err := dialAndQuery(*connect, term, *limit)
+		if err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 1
+		}
+		if *count {
+			fmt.Fprintln(stdout, rep.matched)
+			return 0
+		}
+		results := make([]fuzzy.Result, 0, len(rep.inputs))
+		for _, in := range rep.inputs {
+			// The server only sends back the ranked inputs, so
+			// they're matched again to find what to highlight.
+			r, ok := s.Match(in)
+			if !ok {
+				r = fuzzy.Result{Input: in}
+			}
+			results = append(results, r)
+		}
+		return output(results, rep.matched, rep.total)
+	}
+
+	// An interactive search needs the inputs to be searched again after
+	// each key, so they're kept rather than appended to a searcher.
+	var corpus []string
+	add := s.Append
+	if *interactive {
+		add = func(input ...string) {
+			corpus = append(corpus, input...)
+		}
+	}
+	ingest := func(r io.Reader, add func(...string)) error {
+		r, err := decompress(r)
+		if err != nil {
+			return err
+		}
+		scanner := newScanner(r)
+		scanner.Split(split)
+		for scanner.Scan() {
+			add(scanner.Text())
+		}
+		return scanner.Err()
+	}
+	parse := func(r io.Reader) ([]string, error) {
+		var lines []string
+		err := ingest(r, func(input ...string) {
+			lines = append(lines, input...)
+		})
+		return lines, err
+	}
+	// With a cache, the input is still read in full, but it's only parsed
+	// when it's changed since it was cached.
+	read := func(r io.Reader) error {
+		if *cacheDir == "" {
+			return ingest(r, add)
+		}
+		lines, _, err := cachedLines(r, *cacheDir, parse)
+		if err != nil {
+			return err
+		}
+		add(lines...)
+		return nil
+	}
+	if len(files) == 0 {
+		if err := read(stdin); err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 1
+		}
+	}
+	for _, name := range files {
+		s.StartSource(name)
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 1
+		}
+		err = read(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(stderr, "fz: %s: %v\n", name, err)
+			return 1
+		}
+	}
+
+	if *interactive {
+		t, err := openTerminal()
+		if err != nil {
+			fmt.Fprintln(stderr, "fz:", err)
+			return 1
+		}
+		p := picker{
+			search: func(term string, max int) []fuzzy.Result {
+				s := configure(term, max)
+				s.Append(corpus...)
+				return s.RankedResults(max)
+			},
+			query: []rune(term),
+		}
+		// The picker is always drawn on a terminal, so highlighting is
+		// only disabled when it's turned off explicitly.
+		if *color == "always" || *color == "auto" && !noColor {
+			p.highlight = open
+		}
+		io.WriteString(t.out, "\033[?1049h")
+		selected, ok := pick(t, &p)
+		io.WriteString(t.out, "\033[?1049l")
+		t.close()
+		if !ok {
+			return 130
+		}
+		io.WriteString(stdout, selected+eol)
+		return 0
+	}
+
+	if *count {
+		fmt.Fprintln(stdout, s.Count())
+		return 0
+	}
+
+	return output(s.RankedResults(*limit), s.Matched(), s.Total())
 }
 
-func (s *searcher) rankedResults(max int) []result {
-	close(s.batchSem)
+// decompress returns a reader of r's decompressed contents if r starts with
+// gzip's magic bytes, or of r's contents as they are otherwise.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return br, nil
+	}
+	return gzip.NewReader(br)
+}
 
-	all := byRank([]result{})
-	if len(s.batch) > 0 {
-		for _, b := range s.batch {
-			elemResults := search(b, s.term, 0, nil)
-			if len(elemResults) == 0 {
-				continue
-			}
-			sort.Sort(byRank(elemResults))
-			all = append(all, elemResults[0])
+// parseDelimiter parses a -d delimiter, which is either a single byte or a Go
+// escape sequence for one, such as \t or \x00.
+func parseDelimiter(s string) (byte, error) {
+	if len(s) == 1 {
+		return s[0], nil
+	}
+	d, err := strconv.Unquote(`"` + s + `"`)
+	if err != nil || len(d) != 1 {
+		return 0, fmt.Errorf("delimiter %q must be a single byte", s)
+	}
+	return d[0], nil
+}
+
+// maxLineLength is the most bytes that an input line can have. It's far more
+// than bufio.Scanner's default, so that very long lines are still searched.
+const maxLineLength = 256 << 20
+
+// newScanner returns a bufio.Scanner that reads lines from r of up to
+// maxLineLength bytes.
+func newScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineLength)
+	return scanner
+}
+
+// scanDelimited returns a split function for a bufio.Scanner that splits its
+// input on delim. The final token doesn't need to be terminated by delim.
+func scanDelimited(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// untermWriter writes to w, except that a write that ends with term has term
+// held back until the next write. The final term is never written, so output
+// isn't terminated after its last record.
+type untermWriter struct {
+	w    io.Writer
+	term string
+	held bool
+}
+
+func (u *untermWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	if u.held && len(p) > 0 {
+		if _, err := io.WriteString(u.w, u.term); err != nil {
+			return 0, err
+		}
+		u.held = false
+	}
+	if bytes.HasSuffix(p, []byte(u.term)) {
+		p = p[:len(p)-len(u.term)]
+		u.held = true
+	}
+	if _, err := u.w.Write(p); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// fileList is a flag that can be given more than once, collecting each of its
+// values in order.
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(name string) error {
+	*f = append(*f, name)
+	return nil
+}
+
+// parseArgs parses args with flags and returns the positional arguments.
+// Unlike flags.Parse, flags can follow positional arguments. Any arguments
+// after "--" are positional.
+func parseArgs(flags *flag.FlagSet, args []string) ([]string, error) {
+	var operands []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return operands, nil
 		}
+		if n := len(args) - len(rest); n > 0 && args[n-1] == "--" {
+			return append(operands, rest...), nil
+		}
+		operands = append(operands, rest[0])
+		args = rest[1:]
 	}
+}
+
+// isTerminal reports whether w is a terminal. It's a variable so that tests can
+// pretend to write to one.
+var isTerminal = fileIsTerminal
 
-	for i := 0; i < s.batchCount; i++ {
-		all = append(all, <-s.batchResults...)
+// terminalWidth returns the number of columns of the terminal that w writes to,
+// or 0 if w isn't a terminal or its width is unknown.
+func terminalWidth(w io.Writer) int {
+	f, ok := w.(*os.File)
+	if !ok || !isTerminal(f) {
+		return 0
 	}
-	sort.Sort(all)
-	if len(all) > max {
-		return all[:max]
+	return ttyColumns(f)
+}
+
+// fileIsTerminal reports whether w is a file referring to a character device,
+// such as a terminal.
+func fileIsTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
 	}
-	return all
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
 }
 
-// search performs a recursive fuzzy search for a term in s.
-func search(s, term string, offset int, all []result) []result {
-	// We're at the end of the input; nothing more to search.
-	if offset == len(s) {
-		return all
+// loadWeights reads a weights file, where each line is an input, a tab and an
+// integer boost for that input. The input must exactly match the input line
+// to be boosted, after surrounding whitespace is trimmed.
+func loadWeights(path string) (map[string]int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
 	}
+	defer f.Close()
 
-	// Only search the part of the input after the offset.
-	tail := s[offset:]
-	res := result{input: s}
-	for _, r := range term {
-		i := strings.IndexRune(tail, r)
+	weights := make(map[string]int)
+	scanner := newScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		i := strings.LastIndexByte(text, '\t')
 		if i == -1 {
-			break
-		}
-
-		// Check if there was a gap between the previous rune match and
-		// this rune match. If we didn't advance, then there's no gap
-		// and we increment the last span. Otherwise, start a new span
-		// at the current position.
-		if i == 0 {
-			if len(res.matches) == 0 {
-				res.matches = append(res.matches, span{
-					start: offset,
-					end:   offset + 1,
-				})
-			} else {
-				res.matches[len(res.matches)-1].end++
+			return nil, fmt.Errorf("%s:%d: missing tab before weight", path, n)
+		}
+		w, err := strconv.Atoi(strings.TrimSpace(text[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, n, text[i+1:])
+		}
+		weights[strings.TrimSpace(text[:i])] = w
+	}
+	return weights, scanner.Err()
+}
+
+// newDecoder returns a function that decodes inputs in the named encoding, or
+// nil if name is empty.
+func newDecoder(name string) (func(string) (string, error), error) {
+	switch name {
+	case "":
+		return nil, nil
+	case "base64":
+		return func(s string) (string, error) {
+			b, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				b, err = base64.RawStdEncoding.DecodeString(s)
 			}
-		} else {
-			res.matches = append(res.matches, span{
-				start: offset + i,
-				end:   offset + i + 1},
-			)
+			return string(b), err
+		}, nil
+	case "hex":
+		return func(s string) (string, error) {
+			b, err := hex.DecodeString(s)
+			return string(b), err
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown decoding %q: want base64 or hex", name)
+}
+
+// bucket is a labeled tier of ranked results.
+type bucket struct {
+	name    string
+	results []fuzzy.Result
+}
+
+// bucketResults groups ranked results into exact, strong and weak tiers
+// according to the fraction of the term's runes each result matched, as given
+// by s.NormalizedScore. bounds holds the minimum fractions for the exact and
+// strong tiers. Empty tiers are omitted and results keep their ranked order
+// within a tier.
+func bucketResults(results []fuzzy.Result, s *fuzzy.Searcher, bounds [2]float64) []bucket {
+	tiers := []bucket{{name: "exact"}, {name: "strong"}, {name: "weak"}}
+	for _, r := range results {
+		coverage := s.NormalizedScore(r)
+		switch {
+		case coverage >= bounds[0]:
+			tiers[0].results = append(tiers[0].results, r)
+		case coverage >= bounds[1]:
+			tiers[1].results = append(tiers[1].results, r)
+		default:
+			tiers[2].results = append(tiers[2].results, r)
 		}
+	}
 
-		i++
-		tail = tail[i:]
-		offset += i
+	nonEmpty := tiers[:0]
+	for _, t := range tiers {
+		if len(t.results) > 0 {
+			nonEmpty = append(nonEmpty, t)
+		}
+	}
+	return nonEmpty
+}
+
+// parseBucketBounds parses a comma-separated pair of exact and strong tier
+// bounds, such as "1,0.5".
+func parseBucketBounds(s string) ([2]float64, error) {
+	var bounds [2]float64
+	fields := strings.Split(s, ",")
+	if len(fields) != len(bounds) {
+		return bounds, fmt.Errorf("invalid bucket bounds %q: want exact,strong", s)
 	}
+	for i, f := range fields {
+		b, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		if err != nil {
+			return bounds, fmt.Errorf("invalid bucket bound %q", f)
+		}
+		bounds[i] = b
+	}
+	if bounds[1] > bounds[0] {
+		return bounds, fmt.Errorf("invalid bucket bounds %q: strong bound is above exact", s)
+	}
+	return bounds, nil
+}
 
-	// If the score is 0 then we didn't find anything, so don't bother
-	// returning a match.
-	if res.matchScore() == 0 {
-		return all
+// printPlain writes the result's input without any highlighting, but with any
+// escapes that were stripped from it written back before the bytes they
+// originally preceded.
+func printPlain(w io.Writer, r fuzzy.Result) {
+	pos := 0
+	for _, e := range r.Escapes {
+		io.WriteString(w, r.Input[pos:e.Pos])
+		io.WriteString(w, e.Seq)
+		pos = e.Pos
 	}
+	io.WriteString(w, r.Input[pos:])
+}
 
-	// Search the input again starting after the first matched rune. This
-	// lets us find any better matches that start later in the input. For
-	// example, in:
-	//
-	// s = CxxxAxxxTCAT
-	// term = CAT
-	//
-	// the last 3 characters are the best match (it matches the term
-	// perfectly without gaps). If we didn't recursively search the input,
-	// then we would only match on the first 'C', 'A', and 'T', returning a
-	// suboptimal match of "CxxxAxxxT".
-	//
-	// This yields an exponential runtime, but whatever let's see how it
-	// goes.
-	return search(s, term, res.matches[0].start+1, append(all, res))
+// sortOrders maps the modes accepted by -sort to the orders they sort results
+// in, where nil is the searcher's ranking.
+var sortOrders = map[string]func([]fuzzy.Result) sort.Interface{
+	"score":  nil,
+	"length": func(r []fuzzy.Result) sort.Interface { return fuzzy.ByLength(r) },
+	"input":  func(r []fuzzy.Result) sort.Interface { return fuzzy.ByInput(r) },
 }
 
-// byRank sorts results by their match score, then gap score, then shortest
-// length.
-type byRank []result
+// highlightStyles maps the names accepted by -hl-style to their SGR codes.
+var highlightStyles = map[string]string{
+	"bold":      "1",
+	"underline": "4",
+	"reverse":   "7",
+	"none":      "",
+}
 
-func (r byRank) Len() int {
-	return len(r)
+// highlightOpen returns the escape sequence that starts a highlight in style
+// and the SGR parameters color, or an empty string if matches shouldn't be
+// highlighted at all. set holds the names of the flags that were given. For
+// compatibility with when only -hl-color existed, a color given without an
+// explicit style replaces the default bold style, so a highlight can be a
+// color alone.
+func highlightOpen(set map[string]bool, style, color string) string {
+	var codes []string
+	if code := highlightStyles[style]; code != "" && (set["hl-style"] || !set["hl-color"]) {
+		codes = append(codes, code)
+	}
+	if set["hl-color"] {
+		codes = append(codes, color)
+	}
+	if len(codes) == 0 {
+		return ""
+	}
+	return "\033[" + strings.Join(codes, ";") + "m"
 }
 
-func (r byRank) Swap(i, j int) {
-	r[i], r[j] = r[j], r[i]
+// parseHighlightColor parses an -hl-color value into SGR parameters. The
+// value is either a single SGR code from 1 to 107, or fg= or bg= followed by
+// 5;N for color N of the 256-color palette or 2;R;G;B for a truecolor. The
+// extended forms may also start with the 38 or 48 that introduces them.
+func parseHighlightColor(s string) (string, error) {
+	invalid := fmt.Errorf("invalid highlight color %q: want an SGR code from 1 to 107, fg=5;N or fg=2;R;G;B", s)
+	if code, err := strconv.Atoi(s); err == nil {
+		if code < 1 || code > 107 {
+			return "", invalid
+		}
+		return s, nil
+	}
+
+	var intro string
+	switch {
+	case strings.HasPrefix(s, "fg="):
+		intro = "38"
+	case strings.HasPrefix(s, "bg="):
+		intro = "48"
+	default:
+		return "", invalid
+	}
+	params := strings.Split(s[len("fg="):], ";")
+	if params[0] == intro {
+		params = params[1:]
+	}
+	if len(params) == 0 {
+		return "", invalid
+	}
+	switch {
+	case params[0] == "5" && len(params) == 2:
+	case params[0] == "2" && len(params) == 4:
+	default:
+		return "", invalid
+	}
+	for _, p := range params[1:] {
+		if n, err := strconv.Atoi(p); err != nil || n < 0 || n > 255 {
+			return "", invalid
+		}
+	}
+	return intro + ";" + strings.Join(params, ";"), nil
 }
 
-func (r byRank) Less(i, j int) bool {
-	if r[i].matchScore() == r[j].matchScore() {
-		if r[i].gapScore() == r[j].gapScore() {
-			return len(r[i].input) < len(r[j].input)
+// expandResultTabs returns a copy of the result with each tab in its input
+// replaced by n spaces. Its spans and escapes are moved to cover the same runes
+// as before, and a highlighted tab becomes n highlighted spaces.
+func expandResultTabs(r fuzzy.Result, n int) fuzzy.Result {
+	var tabs []int
+	for i := 0; i < len(r.Input); i++ {
+		if r.Input[i] == '\t' {
+			tabs = append(tabs, i)
+		}
+	}
+	if len(tabs) == 0 {
+		return r
+	}
+	shift := func(pos int) int {
+		return pos + (n-1)*sort.SearchInts(tabs, pos)
+	}
+	shiftSpans := func(spans []fuzzy.Span) []fuzzy.Span {
+		var shifted []fuzzy.Span
+		for _, s := range spans {
+			shifted = append(shifted, fuzzy.Span{Start: shift(s.Start), End: shift(s.End)})
 		}
-		return r[i].gapScore() > r[j].gapScore()
+		return shifted
 	}
-	return r[i].matchScore() > r[j].matchScore()
+
+	expanded := r
+	expanded.Input = strings.ReplaceAll(r.Input, "\t", strings.Repeat(" ", n))
+	expanded.Matches = shiftSpans(r.Matches)
+	expanded.Extra = shiftSpans(r.Extra)
+	expanded.Escapes = make([]fuzzy.Escape, len(r.Escapes))
+	for i, e := range r.Escapes {
+		e.Pos = shift(e.Pos)
+		expanded.Escapes[i] = e
+	}
+	return expanded
 }
 
-// span is a range of runes in a string.
-type span struct{ start, end int }
+// trimContext returns the result with its input cut down to n runes on either side
+// of its highlights, and whether it had to be cut. Each end that was cut is
+// replaced with "…". Escapes before the window are moved to its start so that
+// the input's colors are still applied, and escapes after it are dropped.
+func trimContext(r fuzzy.Result, n int) (fuzzy.Result, bool) {
+	h := r.Highlights()
+	if len(h) == 0 || r.WholeLine {
+		return r, false
+	}
+	start, end := h[0].Start, h[len(h)-1].End
+	for i := 0; i < n && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(r.Input[:start])
+		start -= size
+	}
+	for i := 0; i < n && end < len(r.Input); i++ {
+		_, size := utf8.DecodeRuneInString(r.Input[end:])
+		end += size
+	}
+	if start == 0 && end == len(r.Input) {
+		return r, false
+	}
 
-// result contains the matches from a search.
-type result struct {
-	// input is the string that was searched.
-	input string
+	const ellipsis = "…"
+	prefix, suffix := "", ""
+	if start > 0 {
+		prefix = ellipsis
+	}
+	if end < len(r.Input) {
+		suffix = ellipsis
+	}
+	shift := func(pos int) int {
+		return pos - start + len(prefix)
+	}
+	shiftSpans := func(spans []fuzzy.Span) []fuzzy.Span {
+		var shifted []fuzzy.Span
+		for _, s := range spans {
+			shifted = append(shifted, fuzzy.Span{Start: shift(s.Start), End: shift(s.End)})
+		}
+		return shifted
+	}
+
+	windowed := r
+	windowed.Input = prefix + r.Input[start:end] + suffix
+	windowed.Matches = shiftSpans(r.Matches)
+	windowed.Extra = shiftSpans(r.Extra)
+	windowed.Escapes = nil
+	for _, e := range r.Escapes {
+		switch {
+		case e.Pos > end:
+			continue
+		case e.Pos < start:
+			e.Pos = 0
+		default:
+			e.Pos = shift(e.Pos)
+		}
+		windowed.Escapes = append(windowed.Escapes, e)
+	}
+	return windowed, true
+}
 
-	// matches contains the spans within the input string where matching
-	// runes were found.
-	matches []span
+// truncate returns the result cut down to fit in n columns, and whether it had
+// to be cut. If its highlights wouldn't fit, the input before its first match
+// is dropped too so that as much of the match as possible stays visible.
+func truncate(r fuzzy.Result, n int) (fuzzy.Result, bool) {
+	if n < 1 {
+		n = 1
+	}
+	if columns(r.Input) <= n {
+		return r, false
+	}
+	if h := r.Highlights(); len(h) > 0 && columns(r.Input[:h[len(h)-1].End]) > n {
+		r = r.FromMatch()
+	}
+	return clip(r, n), true
 }
 
-// matchScore is how well the result matches the search term. The score
-// increases for each search term rune that was found in the input.
-func (r result) matchScore() int {
-	score := 0
-	for _, s := range r.matches {
-		score += s.end - s.start
+// matchPositions returns the offsets in runes of every highlighted rune in the
+// result's input, separated by commas.
+func matchPositions(r fuzzy.Result) string {
+	var b strings.Builder
+	runes, pos := 0, 0
+	for _, h := range r.Highlights() {
+		runes += utf8.RuneCountInString(r.Input[pos:h.Start])
+		for range r.Input[h.Start:h.End] {
+			if b.Len() > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(strconv.Itoa(runes))
+			runes++
+		}
+		pos = h.End
 	}
-	return score
+	return b.String()
 }
 
-// gapScore is a negative value that corresponds to how many gaps must be
-// inserted into the search term to find a match.
-func (r result) gapScore() int {
-	return -len(r.matches) + 1
+// printHighlight writes the result's input with matching runes highlighted by
+// the escape sequence open, such as "\033[1m" for bold.
+func printHighlight(w io.Writer, r fuzzy.Result, open string) {
+	const reset = "\033[0m"
+	highlights := r.Highlights()
+	buf := bytes.Buffer{}
+	buf.Grow(len(r.Input) + len(highlights)*(len(open)+len(reset)))
+
+	// Any escapes that were stripped from the input are written back
+	// before the byte they originally preceded. Since a highlight ends
+	// with a reset, the input's own graphics state is tracked so that it
+	// can be restored afterwards.
+	var state []string
+	inputPos, esc := 0, 0
+	writeTo := func(end int, inHighlight bool) {
+		for ; esc < len(r.Escapes); esc++ {
+			e := r.Escapes[esc]
+			if e.Pos > end || inHighlight && e.Pos == end {
+				break
+			}
+			buf.WriteString(r.Input[inputPos:e.Pos])
+			buf.WriteString(e.Seq)
+			inputPos = e.Pos
+			if !e.IsSGR() {
+				continue
+			}
+			if e.IsReset() {
+				state = state[:0]
+			} else {
+				state = append(state, e.Seq)
+			}
+			if inHighlight {
+				buf.WriteString(open)
+			}
+		}
+		buf.WriteString(r.Input[inputPos:end])
+		inputPos = end
+	}
+
+	for _, m := range highlights {
+		writeTo(m.Start, false)
+		buf.WriteString(open)
+		writeTo(m.End, true)
+		buf.WriteString(reset)
+		for _, seq := range state {
+			buf.WriteString(seq)
+		}
+	}
+	writeTo(len(r.Input), false)
+	buf.WriteTo(w)
 }
 
-// printHighlight writes the result's input with matching runes bolded and
-// colored.
-func (r result) printHighlight(w io.Writer) {
-	escLen := 4
+// printHTML writes the result as a div containing prefix followed by the
+// result's input, with matching runes wrapped in a mark element. All of the
+// text is HTML-escaped.
+func printHTML(w io.Writer, r fuzzy.Result, prefix string) {
 	buf := bytes.Buffer{}
-	buf.Grow(len(r.input) + len(r.matches)*2*escLen)
-	inputPos := 0
-	for _, m := range r.matches {
-		n, _ := buf.WriteString(r.input[:m.start])
-		inputPos += n
+	buf.WriteString("<div>")
+	buf.WriteString(html.EscapeString(prefix))
+	pos := 0
+	for _, h := range r.Highlights() {
+		buf.WriteString(html.EscapeString(r.Input[pos:h.Start]))
+		buf.WriteString("<mark>")
+		buf.WriteString(html.EscapeString(r.Input[h.Start:h.End]))
+		buf.WriteString("</mark>")
+		pos = h.End
+	}
+	buf.WriteString(html.EscapeString(r.Input[pos:]))
+	buf.WriteString("</div>")
+	buf.WriteTo(w)
+}
 
-		buf.WriteString("\033[1m")
+// jsonResult is the representation of a result in -format json and jsonl
+// output.
+type jsonResult struct {
+	Input           string     `json:"input"`
+	MatchScore      int        `json:"matchScore"`
+	GapScore        int        `json:"gapScore"`
+	NormalizedScore float64    `json:"normalizedScore"`
+	Spans           []jsonSpan `json:"spans"`
+}
 
-		n, _ = buf.WriteString(r.input[m.start:m.end])
-		inputPos += n
+type jsonSpan struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
 
-		buf.WriteString("\033[0m")
+// resultJSON returns a result's JSON representation, given its normalized
+// score.
+func resultJSON(r fuzzy.Result, normalized float64) jsonResult {
+	j := jsonResult{
+		Input:           r.Input,
+		MatchScore:      r.MatchScore(),
+		GapScore:        r.GapScore(),
+		NormalizedScore: normalized,
+		Spans:           []jsonSpan{},
 	}
-	if inputPos < len(r.input) {
-		buf.WriteString(r.input[inputPos:])
+	for _, h := range r.Highlights() {
+		j.Spans = append(j.Spans, jsonSpan{Start: h.Start, End: h.End})
 	}
-	buf.WriteByte('\n')
-	buf.WriteTo(w)
+	return j
+}
+
+// rgText is ripgrep's representation of arbitrary text in its JSON output.
+type rgText struct {
+	Text string `json:"text"`
+}
+
+type rgSubmatch struct {
+	Match rgText `json:"match"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+}
+
+type rgMatch struct {
+	Path       rgText       `json:"path"`
+	Lines      rgText       `json:"lines"`
+	LineNumber *int         `json:"line_number"`
+	Submatches []rgSubmatch `json:"submatches"`
+}
+
+// printRgJSON writes the result as a ripgrep --json "match" record. The line
+// number is only included if lineNumber is true, otherwise it's null like it
+// is with ripgrep's --no-line-number.
+func printRgJSON(w io.Writer, r fuzzy.Result, lineNumber bool) {
+	m := rgMatch{
+		Path:       rgText{Text: r.SourceName()},
+		Lines:      rgText{Text: r.Input + "\n"},
+		Submatches: []rgSubmatch{},
+	}
+	if lineNumber {
+		m.LineNumber = &r.Line
+	}
+	for _, h := range r.Highlights() {
+		m.Submatches = append(m.Submatches, rgSubmatch{
+			Match: rgText{Text: r.Input[h.Start:h.End]},
+			Start: h.Start,
+			End:   h.End,
+		})
+	}
+
+	b, _ := json.Marshal(struct {
+		Type string  `json:"type"`
+		Data rgMatch `json:"data"`
+	}{"match", m})
+	b = append(b, '\n')
+	w.Write(b)
 }
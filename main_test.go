@@ -1,30 +1,1647 @@
 package main
 
 import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gcurtis/fz/fuzzy"
 )
 
-func benchmarkPathologicalFind(b *testing.B, n, m int) {
-	corpus := make([]string, n)
-	for i := 0; i < len(corpus); i++ {
-		corpus[i] = strings.Repeat("m", m-2) + "oo"
+func init() {
+	// Most tests check the highlighted output, which is only written to
+	// terminals by default.
+	isTerminal = func(io.Writer) bool { return true }
+}
+
+// runFz runs fz with args against a line-delimited stdin and returns what it
+// wrote to stdout and stderr along with its exit code.
+func runFz(t *testing.T, stdin string, args ...string) (stdout, stderr string, code int) {
+	t.Helper()
+	var out, errOut bytes.Buffer
+	code = run(args, strings.NewReader(stdin), &out, &errOut)
+	return out.String(), errOut.String(), code
+}
+
+// exampleFiles is the output of find in the usage examples.
+const exampleFiles = "./main.go\n./main_test.go\n./templates/index.gohtml\n./go.mod\n"
+
+func TestThen(t *testing.T) {
+	stdin := "./main.go\n./main_test.go\n./go.mod\n./README.md\n"
+
+	got, _, _ := runFz(t, stdin, "m")
+	if n := strings.Count(got, "\n"); n != 4 {
+		t.Fatalf("got %d results for broad search, want 4:\n%s", n, got)
+	}
+
+	got, _, code := runFz(t, stdin, "-then", "test", ".go")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	want := "./main_\033[1mtest.go\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	if got, _, _ := runFz(t, "tab.go\nmain.go\n", "-then", "tzzz", ".go"); got != "" {
+		t.Errorf("got output %q for a partial -then match, want none", got)
+	}
+	got, _, _ = runFz(t, stdin, "-then", "ma test", "go")
+	if want := "./\033[1mma\033[0min_\033[1mtest\033[0m.\033[1mgo\033[0m\n"; got != want {
+		t.Errorf("got output %q for a -then with two tokens, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, exampleFiles, "-color", "never", "-then", "test", ".go")
+	if want := "./main_test.go\n./templates/index.gohtml\n"; got != want {
+		t.Errorf("got output %q for the usage example, want %q", got, want)
+	}
+}
+
+func TestBucketResults(t *testing.T) {
+	s := fuzzy.New("abcd")
+	s.Append("abcd", "abxx", "abcx", "axxx", "xabcd")
+	buckets := bucketResults(s.RankedResults(maxResults), s, [2]float64{1, 0.5})
+
+	want := []struct {
+		name   string
+		inputs []string
+	}{
+		{"exact", []string{"abcd", "xabcd"}},
+		{"strong", []string{"abcx", "abxx"}},
+		{"weak", []string{"axxx"}},
+	}
+	if len(buckets) != len(want) {
+		t.Fatalf("got %d buckets, want %d", len(buckets), len(want))
+	}
+	for i, b := range buckets {
+		if b.name != want[i].name {
+			t.Errorf("got bucket %d name %q, want %q", i, b.name, want[i].name)
+		}
+		var got []string
+		for _, r := range b.results {
+			got = append(got, r.Input)
+		}
+		if strings.Join(got, ",") != strings.Join(want[i].inputs, ",") {
+			t.Errorf("got %s bucket %v, want %v", b.name, got, want[i].inputs)
+		}
+	}
+}
+
+func TestBucketRunes(t *testing.T) {
+	for _, tt := range []struct {
+		term, input, want string
+	}{
+		// The space between the words isn't part of the match.
+		{"foo bar", "foo-bar", "exact"},
+		{"éa", "é", "weak"},
+		{"éa", "éa", "exact"},
+		{"", "anything", "exact"},
+	} {
+		s := fuzzy.New(tt.term)
+		s.Append(tt.input)
+		buckets := bucketResults(s.RankedResults(maxResults), s, [2]float64{1, 0.6})
+		if len(buckets) != 1 || buckets[0].name != tt.want {
+			t.Errorf("got buckets %+v for %q in %q, want it %s", buckets, tt.term, tt.input, tt.want)
+		}
+	}
+}
+
+func TestBucketLimit(t *testing.T) {
+	var stdin strings.Builder
+	for i := 0; i < maxResults; i++ {
+		stdin.WriteString("abcd\n")
+	}
+	stdin.WriteString("abxx\n")
+
+	got, _, _ := runFz(t, stdin.String(), "-bucket", "abcd")
+	if strings.Contains(got, "strong:") {
+		t.Errorf("got strong bucket past the result limit:\n%s", got)
+	}
+	if n := strings.Count(got, "abcd"); n != maxResults {
+		t.Errorf("got %d exact results, want %d", n, maxResults)
+	}
+}
+
+func TestBucketOverlappingTokens(t *testing.T) {
+	// Both tokens fully match abc, even though they share its b.
+	got, _, _ := runFz(t, "abc\nabx\n", "-bucket", "-color", "never", "ab bc")
+	if want := "exact:\nabc\nstrong:\nabx\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestBucketFormats(t *testing.T) {
+	got, _, _ := runFz(t, "abcd\nabxx\n", "-bucket", "-format", "html", "abcd")
+	if want := "<h2>exact</h2>\n<div><mark>abcd</mark></div>\n<h2>strong</h2>\n<div><mark>ab</mark>xx</div>\n"; got != want {
+		t.Errorf("got html output %q, want %q", got, want)
+	}
+	for _, args := range [][]string{{"-format", "json"}, {"-format", "jsonl"}, {"-rg-json"}} {
+		if _, _, code := runFz(t, "abcd\n", append(args, "-bucket", "abcd")...); code != 2 {
+			t.Errorf("got exit code %d for -bucket with %q, want 2", code, args)
+		}
+	}
+}
+
+func TestLineNumbers(t *testing.T) {
+	stdin := "package main\n\nimport \"os\"\n\nfunc main() {\n\tos.Exit(1)\n}\n"
+	got, _, _ := runFz(t, stdin, "-line-number", "os")
+	want := "6:\033[1mos\033[0m.Exit(1)\n3:import \"\033[1mos\033[0m\"\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	// "hello world", "goodbye world", an invalid line and "hello" without
+	// padding.
+	stdin := "aGVsbG8gd29ybGQ=\nZ29vZGJ5ZSB3b3JsZA==\nnot*base64\naGVsbG8\n"
+
+	got, _, _ := runFz(t, stdin, "-decode", "base64", "hello")
+	want := "\033[1maGVsbG8\033[0m\n\033[1maGVsbG8gd29ybGQ=\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-decode", "base64", "-show-decoded", "bye")
+	want = "\033[1mgoodbye world\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-decode", "base64", "*")
+	if got != "" {
+		t.Errorf("got output %q for a search only matching invalid input, want none", got)
+	}
+
+	got, _, _ = runFz(t, "68656c6c6f\nzz\n", "-decode", "hex", "-show-decoded", "hello")
+	want = "\033[1mhello\033[0m\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	if _, _, code := runFz(t, stdin, "-decode", "rot13", "hello"); code != 2 {
+		t.Errorf("got exit code %d for an unknown decoding, want 2", code)
+	}
+}
+
+func TestSearchWindow(t *testing.T) {
+	got, _, _ := runFz(t, "axxxxxxxxbxc\nxxaxbxcxxxxx\n", "-strict-order", "-window", "2", "abc")
+	want := "xx\033[1ma\033[0mx\033[1mb\033[0mx\033[1mc\033[0mxxxxx\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestRgJSON(t *testing.T) {
+	got, _, _ := runFz(t, "foo\n\npeople\n", "-rg-json", "-line-number", "pl")
+
+	var record struct {
+		Type string `json:"type"`
+		Data struct {
+			Path struct {
+				Text string `json:"text"`
+			} `json:"path"`
+			Lines struct {
+				Text string `json:"text"`
+			} `json:"lines"`
+			LineNumber *int `json:"line_number"`
+			Submatches []struct {
+				Match struct {
+					Text string `json:"text"`
+				} `json:"match"`
+				Start int `json:"start"`
+				End   int `json:"end"`
+			} `json:"submatches"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal([]byte(got), &record); err != nil {
+		t.Fatalf("got invalid JSON record %q: %v", got, err)
+	}
+	if record.Type != "match" {
+		t.Errorf("got record type %q, want match", record.Type)
+	}
+	if record.Data.Path.Text != "<stdin>" {
+		t.Errorf("got path %q, want <stdin>", record.Data.Path.Text)
+	}
+	if record.Data.Lines.Text != "people\n" {
+		t.Errorf("got lines %q, want %q", record.Data.Lines.Text, "people\n")
+	}
+	if record.Data.LineNumber == nil || *record.Data.LineNumber != 3 {
+		t.Errorf("got line number %v, want 3", record.Data.LineNumber)
+	}
+	sub := record.Data.Submatches
+	if len(sub) != 1 || sub[0].Match.Text != "pl" || sub[0].Start != 3 || sub[0].End != 5 {
+		t.Errorf("got submatches %+v, want pl at 3-5", sub)
+	}
+
+	got, _, _ = runFz(t, "people\n", "-rg-json", "pl")
+	if !strings.Contains(got, `"line_number":null`) {
+		t.Errorf("got record %q, want a null line number without -line-number", got)
+	}
+}
+
+func TestNgram(t *testing.T) {
+	stdin := "pepper\npeople\n"
+
+	// "pepole" is a typo of "people", but strict subsequence matching
+	// matches more of it in "pepper".
+	got, _, _ := runFz(t, stdin, "pepole")
+	if !strings.HasPrefix(got, "\033[1mpep\033[0mper\n") {
+		t.Errorf("got output %q, want pepper first", got)
+	}
+
+	got, _, _ = runFz(t, stdin, "-ngram", "2", "pepole")
+	want := "\033[1mpe\033[0mop\033[1mle\033[0m\n\033[1mpep\033[0mper\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	for _, args := range [][]string{{"-i", "hello"}, {"-smart-case", "hello"}, {"-case-rank", "hello"}, {"-leet", "-i", "h3ll0"}} {
+		got, _, _ := runFz(t, "HELLO\n", append([]string{"-ngram", "2", "-color", "never"}, args...)...)
+		if got != "HELLO\n" {
+			t.Errorf("got output %q for %q, want HELLO", got, args)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	var stdin strings.Builder
+	for i := 0; i < maxResults+5; i++ {
+		fmt.Fprintf(&stdin, "foo%d\n", i)
+	}
+
+	for _, tt := range []struct {
+		args []string
+		want int
+	}{
+		{[]string{"foo"}, maxResults},
+		{[]string{"-n", "5", "foo"}, 5},
+		{[]string{"-n", "0", "foo"}, maxResults + 5},
+		{[]string{"-n", "5", "-parallel-merge", "foo"}, 5},
+	} {
+		stdout, _, code := runFz(t, stdin.String(), tt.args...)
+		if code != 0 {
+			t.Fatalf("%q: got exit code %d", tt.args, code)
+		}
+		if got := strings.Count(stdout, "\n"); got != tt.want {
+			t.Errorf("%q: got %d results, want %d", tt.args, got, tt.want)
+		}
+	}
+
+	if _, _, code := runFz(t, "", "-n", "-1", "foo"); code != 2 {
+		t.Errorf("got exit code %d for a negative limit, want 2", code)
+	}
+}
+
+func TestNoColor(t *testing.T) {
+	t.Setenv("NO_COLOR", "")
+	stdout, _, _ := runFz(t, "\033[31mfoo\033[0m\n", "-preserve-ansi", "fo")
+	if strings.Contains(stdout, "\033") {
+		t.Errorf("got escape sequences with NO_COLOR set:\n%q", stdout)
+	}
+	if want := "foo\n"; stdout != want {
+		t.Errorf("got:\n%q\nwant:\n%q", stdout, want)
+	}
+}
+
+func TestColor(t *testing.T) {
+	const stdin = "foo\nbar\n"
+	const plain, highlighted = "foo\n", "\033[1mfo\033[0mo\n"
+	defer func(f func(io.Writer) bool) { isTerminal = f }(isTerminal)
+	isTerminal = func(io.Writer) bool { return false }
+
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"fo"}, plain},
+		{[]string{"-color=auto", "fo"}, plain},
+		{[]string{"-color=never", "fo"}, plain},
+		{[]string{"-color=always", "fo"}, highlighted},
+	} {
+		if got, _, _ := runFz(t, stdin, tt.args...); got != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.args, got, tt.want)
+		}
+	}
+
+	t.Setenv("NO_COLOR", "")
+	if got, _, _ := runFz(t, stdin, "-color=always", "fo"); got != highlighted {
+		t.Errorf("got %q with -color=always and NO_COLOR set, want %q", got, highlighted)
+	}
+	if _, _, code := runFz(t, stdin, "-color=sometimes", "fo"); code != 2 {
+		t.Errorf("got exit code %d for an unknown color mode, want 2", code)
+	}
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fileIsTerminal(f) {
+		t.Error("got a regular file reported as a terminal")
+	}
+}
+
+func TestHighlightColor(t *testing.T) {
+	got, _, _ := runFz(t, "foo\n", "-hl-color", "32", "fo")
+	if want := "\033[32mfo\033[0mo\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, tt := range []struct {
+		color, want string
+	}{
+		{"fg=5;208", "\033[38;5;208mfo\033[0mo\n"},
+		{"fg=38;5;208", "\033[38;5;208mfo\033[0mo\n"},
+		{"bg=5;17", "\033[48;5;17mfo\033[0mo\n"},
+		{"fg=2;255;128;0", "\033[38;2;255;128;0mfo\033[0mo\n"},
+		{"fg=38;2;255;128;0", "\033[38;2;255;128;0mfo\033[0mo\n"},
+	} {
+		got, _, _ := runFz(t, "foo\n", "-hl-color", tt.color, "fo")
+		if got != tt.want {
+			t.Errorf("got %q for %q, want %q", got, tt.color, tt.want)
+		}
+	}
+	for _, code := range []string{"green", "0", "-1", "108", "fg=", "fg=5", "fg=5;256", "fg=2;1;2", "fg=2;1;2;x", "fg=48;5;1", "xx=5;1"} {
+		if _, _, exit := runFz(t, "foo\n", "-hl-color", code, "fo"); exit != 2 {
+			t.Errorf("got exit code %d for highlight color %q, want 2", exit, code)
+		}
+	}
+}
+
+func TestHighlightStyle(t *testing.T) {
+	for _, tt := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-hl-style", "bold"}, "\033[1mfo\033[0mo\n"},
+		{[]string{"-hl-style", "underline"}, "\033[4mfo\033[0mo\n"},
+		{[]string{"-hl-style", "reverse"}, "\033[7mfo\033[0mo\n"},
+		{[]string{"-hl-style", "none"}, "foo\n"},
+		{[]string{"-hl-style", "underline", "-hl-color", "32"}, "\033[4;32mfo\033[0mo\n"},
+		{[]string{"-hl-style", "none", "-hl-color", "32"}, "\033[32mfo\033[0mo\n"},
+	} {
+		got, _, _ := runFz(t, "foo\n", append(tt.args, "fo")...)
+		if got != tt.want {
+			t.Errorf("got %q for %q, want %q", got, tt.args, tt.want)
+		}
+	}
+
+	// A color on its own never brings the bold attribute with it, even
+	// for matches in several spans or from -then.
+	for _, args := range [][]string{{"-hl-color", "31"}, {"-hl-style", "none", "-hl-color", "31"}} {
+		got, _, _ := runFz(t, "foo bar\n", append(args, "-then", "ar", "fob")...)
+		if !strings.Contains(got, "\033[31m") || strings.Contains(got, "\033[1") || strings.Contains(got, "1;") {
+			t.Errorf("got %q for %q, want only the color code", got, args)
+		}
+	}
+	if _, _, code := runFz(t, "foo\n", "-hl-style", "blink", "fo"); code != 2 {
+		t.Errorf("got exit code %d for an unknown style, want 2", code)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	got, _, _ := runFz(t, "a<b>cat\n", "-format", "html", "-line-number", "cat")
+	if want := "<div>1:a&lt;b&gt;<mark>cat</mark></div>\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, _, code := runFz(t, "", "-format", "yaml", "cat"); code != 2 {
+		t.Errorf("got exit code %d for an unknown format, want 2", code)
+	}
+}
+
+func TestJSON(t *testing.T) {
+	const stdin = "people\nply\ndog\n"
+	want := map[string][]fuzzy.Span{}
+	for _, in := range []string{"people", "ply"} {
+		r, _ := fuzzy.Match(in, "pl")
+		want[in] = r.Matches
+	}
+	check := func(format string, got []jsonResult) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d results, want %d", format, len(got), len(want))
+		}
+		for _, j := range got {
+			var spans []fuzzy.Span
+			for _, s := range j.Spans {
+				spans = append(spans, fuzzy.Span{Start: s.Start, End: s.End})
+			}
+			if fmt.Sprint(spans) != fmt.Sprint(want[j.Input]) {
+				t.Errorf("%s: got spans %v for %q, want %v", format, spans, j.Input, want[j.Input])
+			}
+			if j.MatchScore != 2 {
+				t.Errorf("%s: got match score %d for %q, want 2", format, j.MatchScore, j.Input)
+			}
+		}
+	}
+
+	stdout, _, _ := runFz(t, stdin, "-format", "json", "pl")
+	var all []jsonResult
+	if err := json.Unmarshal([]byte(stdout), &all); err != nil {
+		t.Fatalf("got invalid JSON %q: %v", stdout, err)
+	}
+	check("json", all)
+
+	stdout, _, _ = runFz(t, stdin, "-format", "jsonl", "pl")
+	var lines []jsonResult
+	for _, l := range strings.Split(strings.TrimSuffix(stdout, "\n"), "\n") {
+		var j jsonResult
+		if err := json.Unmarshal([]byte(l), &j); err != nil {
+			t.Fatalf("got invalid JSON line %q: %v", l, err)
+		}
+		lines = append(lines, j)
+	}
+	check("jsonl", lines)
+
+	if stdout, _, _ := runFz(t, stdin, "-format", "json", "xyz"); stdout != "[]\n" {
+		t.Errorf("got %q for no results, want an empty array", stdout)
+	}
+}
+
+func TestScore(t *testing.T) {
+	got, _, _ := runFz(t, "people\nplace\n", "-score", "pl")
+	want := "[2,0,1.00] \033[1mpl\033[0mace\n[2,0,1.00] peo\033[1mpl\033[0me\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNormalizedScore(t *testing.T) {
+	got, _, _ := runFz(t, "plxx\nplay\n", "-score", "-color", "never", "play")
+	if want := "[4,0,1.00] play\n[2,0,0.50] plxx\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	stdout, _, _ := runFz(t, "plxx\nplay\n", "-format", "json", "play")
+	var all []jsonResult
+	if err := json.Unmarshal([]byte(stdout), &all); err != nil {
+		t.Fatalf("got invalid JSON %q: %v", stdout, err)
+	}
+	if len(all) != 2 || all[0].NormalizedScore != 1 || all[1].NormalizedScore != 0.5 {
+		t.Errorf("got JSON results %+v, want normalized scores of 1 and 0.5", all)
+	}
+
+	// Both tokens fully match, even though their spans overlap.
+	got, _, _ = runFz(t, "ab\n", "-score", "-color", "never", "ab ab")
+	if want := "[4,0,1.00] ab\n"; got != want {
+		t.Errorf("got %q for overlapping tokens, want %q", got, want)
+	}
+}
+
+func TestNullDelimited(t *testing.T) {
+	stdin := "x\nplayer\x00people\x00\x00dog\x00ply"
+	got, _, _ := runFz(t, stdin, "-0", "-color=never", "pl")
+	if want := "ply\x00x\nplayer\x00people\x00"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, _, code := runFz(t, stdin, "-read0", "-cache-dir", t.TempDir(), "pl"); code != 2 {
+		t.Errorf("got exit code %d for -read0 with -cache-dir, want 2", code)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	// The second search with -cache-dir reads the cached lines.
+	const stdin = "people\r\nply\r\n  place  \r\ndog"
+	cache := t.TempDir()
+	for _, args := range [][]string{
+		{"pl"},
+		{"-no-trim", "pl"},
+		{"-cache-dir", cache, "pl"},
+		{"-cache-dir", cache, "-no-trim", "pl"},
+		{"-rg-json", "pl"},
+	} {
+		got, _, _ := runFz(t, stdin, append([]string{"-color=never"}, args...)...)
+		if strings.Contains(got, "\r") {
+			t.Errorf("got %q for %q, want no carriage returns", got, args)
+		}
+	}
+}
+
+func TestDelimiter(t *testing.T) {
+	got, _, _ := runFz(t, "x\nplayer\tpeople\tdog\tply", "-d", `\t`, "-color=never", "pl")
+	if want := "ply\tx\nplayer\tpeople\t"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, "people,dog,ply", "-d", ",", "-color=never", "pl")
+	if want := "ply,people,"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	for _, d := range []string{"::", `\t\t`, `\u00e9`} {
+		_, stderr, code := runFz(t, "", "-d", d, "pl")
+		if code != 2 || !strings.Contains(stderr, "single byte") {
+			t.Errorf("got exit code %d and %q for delimiter %q, want 2 and a single byte error", code, stderr, d)
+		}
+	}
+}
+
+func TestExclude(t *testing.T) {
+	const stdin = "ply_test\npeople\nplace\nplot.txt\n"
+	got, _, _ := runFz(t, stdin, "pl", "-v", "test")
+	if want := "\033[1mpl\033[0mace\n\033[1mpl\033[0mot.txt\npeo\033[1mpl\033[0me\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "-two-phase", "-v", "test", "pl")
+	if strings.Contains(got, "y_test") {
+		t.Errorf("got an excluded input with -two-phase:\n%s", got)
+	}
+
+	// Partially matching the excluded term isn't enough to be dropped.
+	got, _, _ = runFz(t, stdin, "pl", "-v", "txt")
+	if !strings.Contains(got, "y_test") || strings.Contains(got, "ot.txt") {
+		t.Errorf("got %q, want only plot.txt excluded", got)
+	}
+
+	if got, _, _ := runFz(t, "a-v\n", "--", "-v"); got != "a\033[1m-v\033[0m\n" {
+		t.Errorf("got %q for a term after --, want it searched", got)
+	}
+
+	// The usage example drops index.gohtml, which matches every rune of
+	// test.
+	got, _, _ = runFz(t, exampleFiles, ".go", "-v", "test")
+	if want := "./main\033[1m.go\033[0m\n\033[1m.\033[0m/\033[1mgo\033[0m.mod\n"; got != want {
+		t.Errorf("got %q for the usage example, want %q", got, want)
+	}
+}
+
+func TestAndTerms(t *testing.T) {
+	const stdin = "main.go\nsrc/other.go\nsrc/cmd/main.go\nsrc/main.go\n"
+	got, _, _ := runFz(t, stdin, "src main")
+	want := "\033[1msrc\033[0m/\033[1mmain\033[0m.go\n\033[1msrc\033[0m/cmd/\033[1mmain\033[0m.go\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-two-phase", "src main")
+	if got != want {
+		t.Errorf("got %q with -two-phase, want %q", got, want)
+	}
+}
+
+func TestTwoPhaseNoLimit(t *testing.T) {
+	stdin := "people\nply\ndog\nplace\n"
+	want, _, _ := runFz(t, stdin, "-n", "0", "pl")
+	for _, args := range [][]string{{"-two-phase"}, {"-parallel-merge", "-batch-bytes", "4"}} {
+		got, _, code := runFz(t, stdin, append(args, "-n", "0", "pl")...)
+		if code != 0 {
+			t.Fatalf("got exit code %d for %q, want 0", code, args)
+		}
+		if got != want || strings.Count(got, "\n") != 3 {
+			t.Errorf("got output %q for %q without a limit, want %q", got, args, want)
+		}
+	}
+}
+
+func TestWordBoundary(t *testing.T) {
+	got, _, _ := runFz(t, "./mapp.go\n./app/main.go\n", "app")
+	if want := "./\033[1mapp\033[0m/main.go\n./m\033[1mapp\033[0m.go\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCamelHump(t *testing.T) {
+	got, _, _ := runFz(t, "g1F2R3456789\ngetFooReader\n", "gFR")
+	if want := "\033[1mg\033[0met\033[1mF\033[0moo\033[1mR\033[0meader\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got %q, want getFooReader first", got)
+	}
+}
+
+func TestPathRank(t *testing.T) {
+	const stdin = "main/src/other.go\nsrc/cmd/main.go\n"
+	got, _, _ := runFz(t, stdin, "-path", "main")
+	if want := "src/cmd/\033[1mmain\033[0m.go\n\033[1mmain\033[0m/src/other.go\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "main")
+	if !strings.HasPrefix(got, "\033[1mmain\033[0m/src/other.go\n") {
+		t.Errorf("got %q without -path, want main/src/other.go first", got)
+	}
+}
+
+func TestPrefixMatch(t *testing.T) {
+	got, _, _ := runFz(t, "ergonomics\ngopher\n", "go")
+	if !strings.HasPrefix(got, "\033[1mgo\033[0mpher\n") {
+		t.Errorf("got %q, want gopher first", got)
+	}
+	got, _, _ = runFz(t, "a/main.go\nmain/xx.go\n", "main")
+	if !strings.HasPrefix(got, "\033[1mmain\033[0m/xx.go\n") {
+		t.Errorf("got %q, want main/xx.go first", got)
+	}
+}
+
+func TestSuffixMatch(t *testing.T) {
+	got, _, _ := runFz(t, "goroutine.md\nx.go\n", "-color", "never", "go")
+	if want := "x.go\ngoroutine.md\n"; got != want {
+		t.Errorf("got %q, want x.go first", got)
+	}
+}
+
+func TestLongestSpan(t *testing.T) {
+	got, _, _ := runFz(t, "axbxc\nxabcx\n", "-color", "never", "abc")
+	if want := "xabcx\naxbxc\n"; got != want {
+		t.Errorf("got %q, want the adjacent match first", got)
+	}
+
+	// Both have one gap of one rune, but the second's runs are longer.
+	got, _, _ = runFz(t, "abxcd\naxbcd\n", "-color", "never", "abcd")
+	if want := "axbcd\nabxcd\n"; got != want {
+		t.Errorf("got %q, want the longer span first", got)
+	}
+}
+
+func TestFirstStart(t *testing.T) {
+	got, _, _ := runFz(t, "xxbcxx\nybcyyy\n", "-color", "never", "bc")
+	if want := "ybcyyy\nxxbcxx\n"; got != want {
+		t.Errorf("got %q, want the earlier match first", got)
+	}
+}
+
+func TestGapSize(t *testing.T) {
+	// Both have one gap and a match at the start of a word, but the
+	// sprawling match also ends the input.
+	got, _, _ := runFz(t, "axxxxb\naxbyyy\n", "-color", "never", "ab")
+	if want := "axbyyy\naxxxxb\n"; got != want {
+		t.Errorf("got %q, want the tight match first", got)
+	}
+}
+
+func TestCount(t *testing.T) {
+	var stdin strings.Builder
+	for i := 0; i < maxResults+5; i++ {
+		fmt.Fprintf(&stdin, "foo%d\nbar%d\n", i, i)
 	}
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		searcher := newSearcher("moo")
-		for _, s := range corpus {
-			searcher.append(s)
+	for _, args := range [][]string{
+		{"-c", "foo"},
+		{"-c", "-n", "5", "foo"},
+		{"-c", "-two-phase", "foo"},
+	} {
+		if got, _, code := runFz(t, stdin.String(), args...); got != "30\n" || code != 0 {
+			t.Errorf("got %q with exit code %d for %q, want 30", got, code, args)
 		}
-		b.ReportMetric(float64(searcher.batchCount/b.N), "jobs/op")
-		searcher.rankedResults(maxResults)
+	}
+	if got, _, _ := runFz(t, stdin.String(), "-c", "-v", "1", "foo"); got != "18\n" {
+		t.Errorf("got %q with -v, want only the inputs that aren't excluded counted", got)
+	}
+	if got, _, _ := runFz(t, "dog\n", "-c", "xyz"); got != "0\n" {
+		t.Errorf("got %q for no matches, want 0", got)
+	}
+}
+
+func TestUnique(t *testing.T) {
+	const stdin = "ply\nplace\nply\n  ply\ndog\n"
+	got, _, _ := runFz(t, stdin, "-color", "never", "-u", "pl")
+	if want := "ply\nplace\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "-color", "never", "-u", "-line-number", "pl")
+	if want := "1:ply\n2:place\n"; got != want {
+		t.Errorf("got %q, want the first occurrence kept", got)
+	}
+	if got, _, _ := runFz(t, stdin, "-u", "-c", "pl"); got != "2\n" {
+		t.Errorf("got count %q, want 2", got)
+	}
+}
+
+func TestNoTrim(t *testing.T) {
+	const stdin = "  spaced  \n\n"
+	if got, _, _ := runFz(t, stdin, "-color", "never", "-no-trim", "spaced"); got != "  spaced  \n" {
+		t.Errorf("got %q with -no-trim, want the surrounding spaces kept", got)
+	}
+	if got, _, _ := runFz(t, stdin, "-color", "never", "spaced"); got != "spaced\n" {
+		t.Errorf("got %q, want the input trimmed", got)
+	}
+	if got, _, _ := runFz(t, "a b\n\n", "-color", "never", "-no-trim", "-n", "0", " "); got != "a b\n" {
+		t.Errorf("got %q, want empty inputs skipped with -no-trim", got)
+	}
+}
+
+func TestPositions(t *testing.T) {
+	got, _, _ := runFz(t, "日本語のテキスト\ncafé-menu\n", "-positions", "-line-number", "本ト")
+	if want := "1:日本語のテキスト\t1,7\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, "café-menu\n", "-positions", "é-me")
+	if want := "café-menu\t3,4,5,6\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if _, _, code := runFz(t, "", "-positions", "-format", "json", "x"); code != 2 {
+		t.Errorf("got exit code %d for -positions with -format, want 2", code)
+	}
+}
+
+func TestExactBoost(t *testing.T) {
+	got, _, _ := runFz(t, "c-a-t.txt\nconcatenate\n", "-color=never", "cat")
+	if want := "concatenate\nc-a-t.txt\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Scattered runes in a path's basename earn a bonus with -path, but
+	// it's less than a contiguous match's.
+	got, _, _ = runFz(t, "src/c-a-t.go\nconcat/x.go\n", "-color=never", "-path", "cat")
+	if want := "concat/x.go\nsrc/c-a-t.go\n"; got != want {
+		t.Errorf("got %q with -path, want %q", got, want)
+	}
+}
+
+func TestSubstr(t *testing.T) {
+	const stdin = "c-a-t.txt\nconcatenate\nCAT\n"
+	got, _, _ := runFz(t, stdin, "-substr", "cat")
+	if want := "con\033[1mcat\033[0menate\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "-substr", "-i", "-color=never", "cat")
+	if want := "CAT\nconcatenate\n"; got != want {
+		t.Errorf("got %q with -i, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "-substr", "-color=never", "cat nate")
+	if want := "concatenate\n"; got != want {
+		t.Errorf("got %q for several words, want %q", got, want)
+	}
+	if _, _, code := runFz(t, stdin, "-substr", "-ngram", "2", "cat"); code != 2 {
+		t.Errorf("got exit code %d for -substr with -ngram, want 2", code)
+	}
+}
+
+func TestRegexp(t *testing.T) {
+	const stdin = "moo\nmxo\nfoo\nmango tango\n"
+	got, _, _ := runFz(t, stdin, "-e", "m.*o")
+	want := "\033[1mmango tango\033[0m\n\033[1mmoo\033[0m\n\033[1mmxo\033[0m\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	got, _, _ = runFz(t, stdin, "-e", "-i", "O+$")
+	if want := "f\033[1moo\033[0m\nm\033[1moo\033[0m\nmx\033[1mo\033[0m\nmango tang\033[1mo\033[0m\n"; got != want {
+		t.Errorf("got %q with -i, want %q", got, want)
+	}
+	if _, stderr, code := runFz(t, stdin, "-e", "m(o"); code != 2 || !strings.HasPrefix(stderr, "fz: error parsing regexp") {
+		t.Errorf("got exit code %d and %q for an invalid expression, want 2 and an error", code, stderr)
+	}
+}
+
+func TestDeterministicOrder(t *testing.T) {
+	// Enough equally ranked inputs to be split across batches, which can
+	// finish in any order.
+	var stdin strings.Builder
+	for i := 0; i < 30000; i++ {
+		fmt.Fprintf(&stdin, "x%05d-foo\n", (i*7919)%30000)
+	}
+	first, _, _ := runFz(t, stdin.String(), "-n", "0", "-color", "never", "foo")
+	second, _, _ := runFz(t, stdin.String(), "-n", "0", "-color", "never", "foo")
+	if first != second {
+		t.Error("got different output for the same input")
+	}
+	if want := "x00000-foo\nx00001-foo\n"; !strings.HasPrefix(first, want) {
+		t.Errorf("got output starting %q, want ties in lexicographic order", first[:len(want)])
 	}
 }
 
-func BenchmarkPathologicalFind1000(b *testing.B)    { benchmarkPathologicalFind(b, 1000, 100) }
-func BenchmarkPathologicalFind5000(b *testing.B)    { benchmarkPathologicalFind(b, 5000, 100) }
-func BenchmarkPathologicalFind10000(b *testing.B)   { benchmarkPathologicalFind(b, 10000, 100) }
-func BenchmarkPathologicalFind50000(b *testing.B)   { benchmarkPathologicalFind(b, 50000, 100) }
-func BenchmarkPathologicalFind100000(b *testing.B)  { benchmarkPathologicalFind(b, 100000, 100) }
-func BenchmarkPathologicalFind500000(b *testing.B)  { benchmarkPathologicalFind(b, 500000, 100) }
-func BenchmarkPathologicalFind1000000(b *testing.B) { benchmarkPathologicalFind(b, 1000000, 100) }
+func TestFooter(t *testing.T) {
+	var stdin strings.Builder
+	for i := 0; i < maxResults+5; i++ {
+		stdin.WriteString("people\n")
+	}
+	stdin.WriteString("dog\n")
+
+	stdout, stderr, _ := runFz(t, stdin.String(), "-footer", "pl")
+	if want := fmt.Sprintf("%d/%d matches\n", maxResults, maxResults+5); stderr != want {
+		t.Errorf("got footer %q, want %q", stderr, want)
+	}
+	if strings.Contains(stdout, "matches") {
+		t.Errorf("got footer in stdout:\n%s", stdout)
+	}
+
+	if _, stderr, _ := runFz(t, "dog\n", "-footer", "pl"); stderr != "0/0 matches\n" {
+		t.Errorf("got footer %q with no matches, want %q", stderr, "0/0 matches\n")
+	}
+	if _, stderr, _ := runFz(t, stdin.String(), "pl"); stderr != "" {
+		t.Errorf("got stderr %q without -footer, want none", stderr)
+	}
+}
+
+func TestFooterTwoPhase(t *testing.T) {
+	const stdin = "axxxxxxxxxxb\nab\n"
+	args := []string{"-two-phase", "-strict-order", "-window", "2"}
+	stdout, stderr, _ := runFz(t, stdin, append(args, "-footer", "ab")...)
+	if want := "1/1 matches\n"; stderr != want {
+		t.Errorf("got footer %q, want %q", stderr, want)
+	}
+	if strings.Count(stdout, "\n") != 1 {
+		t.Errorf("got output %q, want 1 result", stdout)
+	}
+	if _, stderr, _ := runFz(t, stdin, append(args, "-stats", "ab")...); !strings.Contains(stderr, " 1 matched,") {
+		t.Errorf("got stats %q, want 1 matched", stderr)
+	}
+
+	// Candidates that aren't fully matched are still counted.
+	many := strings.Repeat("people\npal\n", 2500)
+	if _, stderr, _ := runFz(t, many, "-two-phase", "-footer", "pl"); stderr != "25/5000 matches\n" {
+		t.Errorf("got footer %q for 5000 matches, want %q", stderr, "25/5000 matches\n")
+	}
+}
+
+func TestCountWeight(t *testing.T) {
+	// Both inputs have the same best alignment, but "abzab" contains it
+	// twice.
+	stdin := "abxyz\nabzab\n"
+
+	got, _, _ := runFz(t, stdin, "ab")
+	if !strings.HasPrefix(got, "\033[1mab\033[0mxyz\n") {
+		t.Errorf("got output %q, want the tie broken alphabetically", got)
+	}
+
+	got, _, _ = runFz(t, stdin, "-count-weight", "0.1", "ab")
+	if !strings.HasPrefix(got, "\033[1mab\033[0mzab\n") {
+		t.Errorf("got output %q, want abzab first", got)
+	}
+
+	// The bonus is minor, so it doesn't outrank matching more of the term.
+	got, _, _ = runFz(t, "abcx\nabxabxab\n", "-count-weight", "0.1", "abc")
+	if !strings.HasPrefix(got, "\033[1mabc\033[0mx\n") {
+		t.Errorf("got output %q, want abcx first", got)
+	}
+}
+
+func TestLeet(t *testing.T) {
+	stdin := "3l1t3\np@$$w0rd\nelite\nbanana\n"
+
+	if got, _, _ := runFz(t, stdin, "elite"); strings.Contains(got, "3l1t3") {
+		t.Errorf("got leet-speak match without -leet:\n%s", got)
+	}
+
+	got, _, _ := runFz(t, stdin, "-leet", "elite")
+	for _, want := range []string{"\033[1melite\033[0m\n", "\033[1m3l1t3\033[0m\n"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("got output %q, want it to contain %q", got, want)
+		}
+	}
+
+	got, _, _ = runFz(t, stdin, "-leet", "password")
+	if !strings.HasPrefix(got, "\033[1mp@$$w0rd\033[0m\n") {
+		t.Errorf("got output %q, want p@$$w0rd first", got)
+	}
+}
+
+// writeFile writes a temporary file with the given contents and returns its
+// path.
+func writeFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestFiles(t *testing.T) {
+	a := writeFile(t, "a.txt", "dog\npeople\n")
+	b := writeFile(t, "b.txt", "ply\n")
+
+	got, _, code := runFz(t, "place\n", "-with-filename", "-line-number", "pl", a, b)
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	want := b + ":1:\033[1mpl\033[0my\n" + a + ":2:peo\033[1mpl\033[0me\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	if _, stderr, code := runFz(t, "", "pl", a, filepath.Join(t.TempDir(), "missing")); code != 1 || stderr == "" {
+		t.Errorf("got exit code %d and stderr %q for a missing file, want an error", code, stderr)
+	}
+}
+
+func TestInputFile(t *testing.T) {
+	words := writeFile(t, "words.txt", "dog\npeople\nply\n")
+	got, _, code := runFz(t, "place\n", "-f", words, "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mpl\033[0my\npeo\033[1mpl\033[0me\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	more := writeFile(t, "more.txt", "plot\n")
+	got, _, _ = runFz(t, "place\n", "-f", words, "-with-filename", "pl", more)
+	if want := words + ":\033[1mpl\033[0my\n" + more + ":\033[1mpl\033[0mot\n" + words + ":peo\033[1mpl\033[0me\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, "place\n", "-f", words, "-f", more, "-color", "never", "pl")
+	if want := "ply\nplot\npeople\n"; got != want {
+		t.Errorf("got output %q for two -f flags, want %q", got, want)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	if _, stderr, code := runFz(t, "", "-f", words, "-f", missing, "pl"); code != 1 || !strings.Contains(stderr, missing) {
+		t.Errorf("got exit code %d and stderr %q for a missing file, want an error naming it", code, stderr)
+	}
+}
+
+func TestMaxPerSource(t *testing.T) {
+	var files []string
+	for i, n := range []int{10, 2, 6} {
+		files = append(files, writeFile(t, fmt.Sprintf("%d.txt", i), strings.Repeat("people\n", n)))
+	}
+
+	args := append([]string{"-with-filename", "-max-per-source", "3", "pl"}, files...)
+	got, _, _ := runFz(t, "", args...)
+	counts := make(map[string]int)
+	for _, l := range strings.Split(strings.TrimSuffix(got, "\n"), "\n") {
+		counts[strings.SplitN(l, ":", 2)[0]]++
+	}
+	want := map[string]int{files[0]: 3, files[1]: 2, files[2]: 3}
+	for f, n := range want {
+		if counts[f] != n {
+			t.Errorf("got %d results from %s, want %d", counts[f], f, n)
+		}
+	}
+}
+
+func TestEchoOnEmpty(t *testing.T) {
+	stdout, stderr, code := runFz(t, "dog\n\ncat\n", "-echo-on-empty", "pl")
+	if stdout != "" {
+		t.Errorf("got stdout %q with no matches, want none", stdout)
+	}
+	if want := "fz: no matches for \"pl\" in 3 lines\n"; stderr != want {
+		t.Errorf("got stderr %q, want %q", stderr, want)
+	}
+	if code != 0 {
+		t.Errorf("got exit code %d, want 0", code)
+	}
+
+	if _, stderr, _ := runFz(t, "dog\nplace\n", "-echo-on-empty", "pl"); stderr != "" {
+		t.Errorf("got stderr %q with matches, want none", stderr)
+	}
+}
+
+func TestPositional(t *testing.T) {
+	got, _, _ := runFz(t, "main/src.go\nsrc/cmd/main.go\n", "-positional", "src main")
+	if want := "\033[1msrc\033[0m/cmd/\033[1mmain\033[0m.go\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	stdin := "people\nply\n"
+	weights := writeFile(t, "weights", "people\t3\nunused\t10\n")
+
+	if got, _, _ := runFz(t, stdin, "ply"); !strings.HasPrefix(got, "\033[1mply\033[0m\n") {
+		t.Errorf("got output %q, want ply first without weights", got)
+	}
+
+	// people only matches "p" and "l", but its boost outranks the full
+	// match of ply.
+	got, _, code := runFz(t, stdin, "-weights", weights, "ply")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if !strings.HasPrefix(got, "peo\033[1mpl\033[0me\n") {
+		t.Errorf("got output %q, want the boosted people first", got)
+	}
+
+	bad := writeFile(t, "bad", "people 3\n")
+	if _, stderr, code := runFz(t, stdin, "-weights", bad, "ply"); code != 2 || !strings.Contains(stderr, "bad:1") {
+		t.Errorf("got exit code %d and stderr %q for a malformed weights file, want an error", code, stderr)
+	}
+}
+
+func TestPerTierLimit(t *testing.T) {
+	stdin := "dab\ncab\nbab\nxxab\naab\naxb\naxxb\nax\n"
+	got, _, _ := runFz(t, stdin, "-per-tier-limit", "2", "ab")
+	want := []string{
+		// Of the five contiguous matches, the two best are kept. They
+		// tie on rank, so the lexicographically first ones win.
+		"a\033[1mab\033[0m",
+		"b\033[1mab\033[0m",
+		// The scattered matches score less, so they're a tier of
+		// their own.
+		"\033[1ma\033[0mx\033[1mb\033[0m",
+		"\033[1ma\033[0mxx\033[1mb\033[0m",
+		"\033[1ma\033[0mx",
+	}
+	if got != strings.Join(want, "\n")+"\n" {
+		t.Errorf("got output:\n%q\nwant:\n%q", got, want)
+	}
+}
+
+func TestCaseRank(t *testing.T) {
+	stdin := "main.go\nMAIN.GO\nMain.go\n"
+
+	// Case never excludes a result, it only reorders them.
+	got, _, _ := runFz(t, stdin, "-case-rank", "Main")
+	want := "\033[1mMain\033[0m.go\n\033[1mmain\033[0m.go\n\033[1mMAIN\033[0m.GO\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-case-rank", "main")
+	want = "\033[1mmain\033[0m.go\n\033[1mMain\033[0m.go\n\033[1mMAIN\033[0m.GO\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// The bonus doesn't outweigh matching more of the term.
+	got, _, _ = runFz(t, "Mxxx\nmain\n", "-case-rank", "Main")
+	if !strings.HasPrefix(got, "\033[1mmain\033[0m\n") {
+		t.Errorf("got output %q, want the full match first", got)
+	}
+}
+
+func TestPrefixRunes(t *testing.T) {
+	got, _, _ := runFz(t, "c-o-n-f-i-g\nmy-config.go\n", "-prefix-runes", "2", "cofig")
+	if want := "my-\033[1mco\033[0mn\033[1mfig\033[0m.go\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// A substring has to start a word too.
+	got, _, _ = runFz(t, "xab\nxab ab\n", "-substr", "-prefix-runes", "2", "-color", "never", "ab")
+	if want := "xab ab\n"; got != want {
+		t.Errorf("got output %q with -substr, want %q", got, want)
+	}
+}
+
+func TestWithID(t *testing.T) {
+	got, _, _ := runFz(t, "ply\nplace\nply\n", "-with-id", "pl")
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d results, want 3:\n%s", len(lines), got)
+	}
+	ids := make(map[string]string)
+	for _, l := range lines {
+		fields := strings.SplitN(l, "\t", 2)
+		if len(fields) != 2 || len(fields[0]) != 8 {
+			t.Fatalf("got result %q, want an 8 character id and a tab", l)
+		}
+		if id, ok := ids[fields[1]]; ok && id != fields[0] {
+			t.Errorf("got ids %s and %s for the same input %q", id, fields[0], fields[1])
+		}
+		ids[fields[1]] = fields[0]
+	}
+	if len(ids) != 2 || ids["\033[1mpl\033[0my"] == ids["\033[1mpl\033[0mace"] {
+		t.Errorf("got ids %v, want a different id for each input", ids)
+	}
+
+	again, _, _ := runFz(t, "place\nply\n", "-with-id", "pl")
+	if !strings.Contains(again, ids["\033[1mpl\033[0my"]+"\t") {
+		t.Errorf("got output %q, want the same id for ply as the previous run", again)
+	}
+}
+
+func TestFromMatch(t *testing.T) {
+	got, _, _ := runFz(t, "./internal/server/main.go\nmain_test.go\n", "-from-match", "main")
+	want := "\033[1mmain\033[0m_test.go\n\033[1mmain\033[0m.go\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Secondary spans before the first match are trimmed along with the
+	// input.
+	got, _, _ = runFz(t, "src/cmd/main.go\n", "-from-match", "-then", "src", "main")
+	if want := "\033[1mmain\033[0m.go\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Colors from before the match are kept.
+	got, _, _ = runFz(t, "\033[34msrc/main.go\033[0m\n", "-from-match", "-preserve-ansi", "main")
+	if want := "\033[34m\033[1mmain\033[0m\033[34m.go\033[0m\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+}
+
+func TestIgnoreCase(t *testing.T) {
+	stdin := "go.mod\nMAIN.GO\n"
+
+	if got, _, _ := runFz(t, "go.mod\n", "GoMod"); got != "" {
+		t.Errorf("got output %q for a case-sensitive search, want none", got)
+	}
+
+	got, _, _ := runFz(t, stdin, "-i", "GoMod")
+	if want := "\033[1mgo\033[0m.\033[1mmod\033[0m\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got output %q, want it to start with %q", got, want)
+	}
+
+	// The original case of the input is highlighted.
+	got, _, _ = runFz(t, stdin, "-i", "main")
+	if want := "\033[1mMAIN\033[0m.GO\n"; !strings.HasPrefix(got, want) {
+		t.Errorf("got output %q, want it to start with %q", got, want)
+	}
+}
+
+func TestSmartCase(t *testing.T) {
+	stdin := "Config.go\nconfig.yaml\n"
+	for _, tt := range []struct {
+		term string
+		want string
+	}{
+		{"config", "Config.go\nconfig.yaml\n"},
+		{"Config", "Config.go\n"},
+		{"CONFIG", "Config.go\n"},
+	} {
+		got, _, _ := runFz(t, stdin, "-smart-case", tt.term)
+		got = strings.NewReplacer("\033[1m", "", "\033[0m", "").Replace(got)
+		if got != tt.want {
+			t.Errorf("got output %q for %q, want %q", got, tt.term, tt.want)
+		}
+	}
+}
+
+func TestMultibyte(t *testing.T) {
+	got, _, _ := runFz(t, "café-menu.txt\n", "café")
+	if want := "\033[1mcafé\033[0m-menu.txt\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Runes separated by a gap, and a term that starts with a multibyte
+	// rune.
+	got, _, _ = runFz(t, "日本語のテキスト\n", "日語ト")
+	if want := "\033[1m日\033[0m本\033[1m語\033[0mのテキス\033[1mト\033[0m\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("got invalid UTF-8 output %q", got)
+	}
+}
+
+func TestInvalidUTF8(t *testing.T) {
+	// The invalid bytes are replaced, and the match after them is still
+	// highlighted on the replaced form.
+	got, _, code := runFz(t, "caf\xe9 \xff\xfeplace\nply\n", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mpl\033[0my\ncaf\ufffd \ufffd\033[1mpl\033[0mace\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("got invalid UTF-8 output %q", got)
+	}
+
+	// A search for an invalid byte matches the replacement.
+	got, _, _ = runFz(t, "a\x80b\n", "-color", "never", "\xc0b")
+	if want := "a\ufffdb\n"; got != want {
+		t.Errorf("got output %q for an invalid search, want %q", got, want)
+	}
+}
+
+func TestQueryFlag(t *testing.T) {
+	path := writeFile(t, "words.txt", "dog\nply\n")
+
+	for _, flag := range []string{"-q", "-query"} {
+		got, _, code := runFz(t, "place\n", "-color", "never", flag, "pl", path)
+		if code != 0 {
+			t.Fatalf("got exit code %d for %s, want 0", code, flag)
+		}
+		if want := "ply\n"; got != want {
+			t.Errorf("got output %q for %s, want %q", got, flag, want)
+		}
+	}
+
+	// An empty query still leaves the first argument to name a file.
+	if _, errOut, code := runFz(t, "", "-q", "", path); code != 0 {
+		t.Errorf("got exit code %d for an empty query, want 0: %s", code, errOut)
+	}
+}
+
+func TestEmptyTerm(t *testing.T) {
+	stdin := "people\n\nply\n  place  \nDog\n"
+	for _, args := range [][]string{{""}, {"-q", ""}, {"-i", "-e", ""}, {"  "}, {"-q", " \t "}} {
+		got, _, code := runFz(t, stdin, args...)
+		if code != 0 {
+			t.Fatalf("got exit code %d for %q, want 0", code, args)
+		}
+		if want := "people\nply\nplace\nDog\n"; got != want {
+			t.Errorf("got output %q for %q, want %q", got, args, want)
+		}
+	}
+
+	got, _, _ := runFz(t, stdin, "-n", "2", "")
+	if want := "people\nply\n"; got != want {
+		t.Errorf("got output %q with a limit, want %q", got, want)
+	}
+}
+
+func TestTermLength(t *testing.T) {
+	long := strings.Repeat("é", maxTermLength+1)
+	_, stderr, code := runFz(t, "people\n", long)
+	if code != 2 || !strings.Contains(stderr, "limit") {
+		t.Errorf("got exit code %d and stderr %q for an over-length search, want an error", code, stderr)
+	}
+	if _, _, code := runFz(t, "people\n", long[:len(long)-len("é")]); code != 0 {
+		t.Errorf("got exit code %d for a search at the limit, want 0", code)
+	}
+}
+
+func TestField(t *testing.T) {
+	stdin := "pl1\tdog\tx\nd2\tpeople\tpl\nd3\tcat\n"
+	got, _, code := runFz(t, stdin, "-field", "2", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "d2\tpeo\033[1mpl\033[0me\tpl\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, "a,b:c,d\nb:c\n", "-color", "never", "-field", "2", "-field-sep", ",", "bc")
+	if want := "a,b:c,d\n"; got != want {
+		t.Errorf("got output %q with a separator, want %q", got, want)
+	}
+
+	for _, args := range [][]string{{"-field", "-1"}, {"-field-sep", ""}} {
+		if _, _, code := runFz(t, stdin, append(args, "pl")...); code != 2 {
+			t.Errorf("got exit code %d for %q, want 2", code, args)
+		}
+	}
+}
+
+func TestExpandTabs(t *testing.T) {
+	got, _, code := runFz(t, "a\tb\tc\n", "-expand-tabs", "3", "-e", "b\tc")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "a   \033[1mb   c\033[0m\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, "\033[31ma\tb\033[0m\n", "-preserve-ansi", "-expand-tabs", "2", "ab")
+	if want := "\033[31m\033[1ma\033[0m\033[31m  \033[1mb\033[0m\033[31m\033[0m\n"; got != want {
+		t.Errorf("got output %q with escapes, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, "a\tb\n", "-positions", "-expand-tabs", "4", "ab")
+	if want := "a\tb\t0,2\n"; got != want {
+		t.Errorf("got output %q with -positions, want %q", got, want)
+	}
+	if _, _, code := runFz(t, "", "-expand-tabs", "-1", "ab"); code != 2 {
+		t.Errorf("got exit code %d for a negative width, want 2", code)
+	}
+}
+
+func TestWidth(t *testing.T) {
+	stdin := "pl" + strings.Repeat("x", 30) + "\n"
+	got, _, code := runFz(t, stdin, "-width", "10", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mpl\033[0mxxxxxxxx\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// A match past the width is kept visible by dropping the start of
+	// the input, and a line number counts towards the width.
+	got, _, _ = runFz(t, strings.Repeat("x", 30)+"plyyyy\n", "-width", "6", "-line-number", "pl")
+	if want := "1:\033[1mpl\033[0myy\n"; got != want {
+		t.Errorf("got output %q for a late match, want %q", got, want)
+	}
+
+	// Multibyte runes aren't split, wide ones take two columns, and the
+	// input's escapes are still reset.
+	got, _, _ = runFz(t, "\033[31m日本語のテキスト\033[0m\n", "-preserve-ansi", "-width", "5", "日")
+	if want := "\033[31m\033[1m日\033[0m\033[31m本\033[0m\n"; got != want {
+		t.Errorf("got output %q with wide runes, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-width", "0", "pl")
+	if n := len(got); n != len(stdin)+len("\033[1m\033[0m") {
+		t.Errorf("got %d bytes of output with no width, want the whole input", n)
+	}
+	if _, _, code := runFz(t, stdin, "-width", "-1", "pl"); code != 2 {
+		t.Errorf("got exit code %d for a negative width, want 2", code)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	stdin := "people\nperson\nplace\nply\ndog\n"
+	want, _, _ := runFz(t, stdin, "pl")
+	got, _, code := runFz(t, stdin, "-jobs", "1", "-batch-bytes", "1", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if got != want {
+		t.Errorf("got output %q with one-byte batches, want %q", got, want)
+	}
+	for _, args := range [][]string{{"-jobs", "0"}, {"-batch-bytes", "0"}, {"-jobs", "-1"}} {
+		if _, _, code := runFz(t, stdin, append(args, "pl")...); code != 2 {
+			t.Errorf("got exit code %d for %q, want 2", code, args)
+		}
+	}
+}
+
+func TestFoldDiacritics(t *testing.T) {
+	stdin := "résumé-café\nresume-cafe\nmenu-cafe\u0301.txt\n"
+	got, _, code := runFz(t, stdin, "-fold-diacritics", "cafe")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	want := "resume-\033[1mcafe\033[0m\n" +
+		"résumé-\033[1mcafé\033[0m\n" +
+		"menu-\033[1mcafe\u0301\033[0m.txt\n"
+	if got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, stdin, "-fold-diacritics", "-i", "-color", "never", "RÉSUMÉ")
+	if want := "resume-cafe\nrésumé-café\n"; got != want {
+		t.Errorf("got output %q for a term with diacritics, want %q", got, want)
+	}
+
+	for _, args := range [][]string{{"-e"}, {"-ngram", "2"}} {
+		if _, _, code := runFz(t, stdin, append(args, "-fold-diacritics", "cafe")...); code != 2 {
+			t.Errorf("got exit code %d for %q, want 2", code, args)
+		}
+	}
+}
+
+func TestGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	io.WriteString(zw, "people\ndog\nply\n")
+	zw.Close()
+	compressed := buf.String()
+
+	got, _, code := runFz(t, compressed, "-color", "never", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "ply\npeople\n"; got != want {
+		t.Errorf("got output %q from gzipped stdin, want %q", got, want)
+	}
+
+	path := writeFile(t, "words.gz", compressed)
+	got, _, _ = runFz(t, "", "-color", "never", "-f", path, "pl")
+	if want := "ply\npeople\n"; got != want {
+		t.Errorf("got output %q from a gzipped file, want %q", got, want)
+	}
+
+	// Input that only starts with the magic bytes isn't valid gzip.
+	if _, _, code := runFz(t, "\x1f\x8bpl\n", "pl"); code != 1 {
+		t.Errorf("got exit code %d for corrupt gzip, want 1", code)
+	}
+	got, _, _ = runFz(t, "\x1f\n", "-color", "never", "\x1f")
+	if want := "\x1f\n"; got != want {
+		t.Errorf("got output %q for a single magic byte, want %q", got, want)
+	}
+}
+
+func TestSort(t *testing.T) {
+	const stdin = "zpl\npeople\nply\napple\npxl\ndog\n"
+	for _, tt := range []struct {
+		mode, want string
+	}{
+		{"score", "ply\nzpl\napple\npeople\npxl\n"},
+		{"length", "ply\nzpl\npxl\napple\npeople\n"},
+		{"input", "apple\npeople\nply\npxl\nzpl\n"},
+	} {
+		for _, extra := range [][]string{nil, {"-n", "2"}, {"-two-phase"}, {"-parallel-merge", "-batch-bytes", "4"}} {
+			args := append([]string{"-color", "never", "-sort", tt.mode}, extra...)
+			got, _, code := runFz(t, stdin, append(args, "pl")...)
+			if code != 0 {
+				t.Fatalf("got exit code %d for %q, want 0", code, args)
+			}
+			want := tt.want
+			if len(extra) == 2 {
+				want = strings.Join(strings.SplitAfter(want, "\n")[:2], "")
+			}
+			if got != want {
+				t.Errorf("got output %q for %q, want %q", got, args, want)
+			}
+		}
+	}
+
+	if _, _, code := runFz(t, stdin, "-sort", "random", "pl"); code != 2 {
+		t.Errorf("got exit code %d for an unknown mode, want 2", code)
+	}
+}
+
+func TestAllSpans(t *testing.T) {
+	got, _, code := runFz(t, "foo bar foo baz fo\n", "-all-spans", "foo")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mfoo\033[0m bar \033[1mfoo\033[0m baz fo\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// The best match isn't always the first one.
+	got, _, _ = runFz(t, "xfoo-foo\n", "-all-spans", "-substr", "foo")
+	if want := "x\033[1mfoo\033[0m-\033[1mfoo\033[0m\n"; got != want {
+		t.Errorf("got output %q for a substring, want %q", got, want)
+	}
+	got, _, _ = runFz(t, "foo bar foo\n", "-all-spans", "-field", "2", "-field-sep", " ", "-then", "ba", "foo")
+	if got != "" {
+		t.Errorf("got output %q for a field without a match, want none", got)
+	}
+	if _, _, code := runFz(t, "foo\n", "-all-spans", "-ngram", "2", "foo"); code != 2 {
+		t.Errorf("got exit code %d with -ngram, want 2", code)
+	}
+	got, _, _ = runFz(t, "foo\tfoo foo\n", "-all-spans", "-field", "2", "foo")
+	if want := "foo\t\033[1mfoo\033[0m \033[1mfoo\033[0m\n"; got != want {
+		t.Errorf("got output %q for a field, want %q", got, want)
+	}
+}
+
+func TestLength(t *testing.T) {
+	got, _, code := runFz(t, "a\nab\nabc\nabcd\naé\n", "-min-len", "2", "-max-len", "3", "a")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1ma\033[0mb\n\033[1ma\033[0mbc\n\033[1ma\033[0mé\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if _, _, code := runFz(t, "a\n", "-min-len", "-1", "a"); code != 2 {
+		t.Errorf("got exit code %d for a negative length, want 2", code)
+	}
+}
+
+func TestStats(t *testing.T) {
+	stdout, stderr, code := runFz(t, "foo\nbar\n\nfoo2\nbaz\n", "-stats", "-batch-bytes", "3", "foo")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mfoo\033[0m\n\033[1mfoo\033[0m2\n"; stdout != want {
+		t.Errorf("got output %q, want %q", stdout, want)
+	}
+	if !regexp.MustCompile(`^fz: 5 lines, 2 matched, 4 batches in \S+s\n$`).MatchString(stderr) {
+		t.Errorf("got stats %q, want 5 lines, 2 matched and 4 batches", stderr)
+	}
+}
+
+func TestNoTrailingNewline(t *testing.T) {
+	got, _, code := runFz(t, "foo\nfoo2\n", "-no-trailing-newline", "-color", "never", "foo")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "foo\nfoo2"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	got, _, _ = runFz(t, "foo\x00foo2\x00", "-0", "-no-trailing-newline", "-color", "never", "foo")
+	if want := "foo\x00foo2"; got != want {
+		t.Errorf("got output %q with -0, want %q", got, want)
+	}
+	got, _, _ = runFz(t, "foo\n", "-0", "-no-trailing-newline", "-format", "jsonl", "foo")
+	if got == "" || got[len(got)-1] == '\n' {
+		t.Errorf("got output %q with -format jsonl, want it not to end with a newline", got)
+	}
+}
+
+func TestFoldCaseUnicode(t *testing.T) {
+	got, _, code := runFz(t, "ΟΔΥΣΣΕΥΣ\nSTRAẞE\n", "-i", "-color", "never", "ς")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "ΟΔΥΣΣΕΥΣ\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+	if got, _, _ := runFz(t, "ΟΔΥΣΣΕΥΣ\nSTRAẞE\n", "-i", "-color", "never", "straße"); got != "STRAẞE\n" {
+		t.Errorf("got output %q, want STRAẞE", got)
+	}
+}
+
+func TestNonMatching(t *testing.T) {
+	stdin := "people\ndog\nply\ncat\n\ngoat\n"
+	got, _, code := runFz(t, stdin, "-non-matching", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "dog\ncat\ngoat\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Inputs are split across batches as well as matched serially.
+	got, _, _ = runFz(t, stdin, "-non-matching", "-batch-bytes", "4", "-n", "2", "pl")
+	if want := "dog\ncat\n"; got != want {
+		t.Errorf("got output %q in batches, want %q", got, want)
+	}
+}
+
+func TestBest(t *testing.T) {
+	got, _, code := runFz(t, "people\nply\nplace\n", "-1", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "\033[1mpl\033[0my\n"; got != want {
+		t.Errorf("got output %q, want only the best result %q", got, want)
+	}
+
+	defer func(f func(io.Writer) bool) { isTerminal = f }(isTerminal)
+	isTerminal = func(io.Writer) bool { return false }
+	if got, _, _ := runFz(t, "people\nply\n", "-1", "pl"); got != "ply\n" {
+		t.Errorf("got output %q when stdout isn't a terminal, want it without highlights", got)
+	}
+	if got, _, _ := runFz(t, "people\nply\n", "-1", "-color", "always", "pl"); got != "\033[1mpl\033[0my\n" {
+		t.Errorf("got output %q with -color always, want it highlighted", got)
+	}
+
+	got, _, code = runFz(t, "dog\ncat\n", "-1", "pl")
+	if code != 1 || got != "" {
+		t.Errorf("got exit code %d and output %q without a match, want 1 and nothing", code, got)
+	}
+}
+
+func TestPrefer(t *testing.T) {
+	// Every input starts with a contiguous match of the search, so
+	// they're only told apart by the final tiebreakers.
+	stdin := "src/a/b/c\nsrc/longer-name\nsrc/x\n"
+	for _, tt := range []struct {
+		prefer string
+		want   string
+	}{
+		{"short", "src/x\nsrc/a/b/c\nsrc/longer-name\n"},
+		{"shallow", "src/x\nsrc/longer-name\nsrc/a/b/c\n"},
+	} {
+		got, _, code := runFz(t, stdin, "-prefer", tt.prefer, "-color", "never", "src")
+		if code != 0 {
+			t.Fatalf("got exit code %d for -prefer %s, want 0", code, tt.prefer)
+		}
+		if got != tt.want {
+			t.Errorf("got output %q for -prefer %s, want %q", got, tt.prefer, tt.want)
+		}
+	}
+	if _, _, code := runFz(t, stdin, "-prefer", "deep", "src"); code != 2 {
+		t.Errorf("got exit code %d for an unknown preference, want 2", code)
+	}
+}
+
+func TestContext(t *testing.T) {
+	stdin := "some/very/long/path/to/the/main.go/file/that/goes/on\n"
+	got, _, code := runFz(t, stdin, "-context", "5", "main.go")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := "…/the/\033[1mmain.go\033[0m/file…\n"; got != want {
+		t.Errorf("got output %q, want %q", got, want)
+	}
+
+	// Context is counted in runes, and an end within it isn't cut.
+	got, _, _ = runFz(t, "日本語のテキスト\n", "-context", "2", "テ")
+	if want := "…語の\033[1mテ\033[0mキス…\n"; got != want {
+		t.Errorf("got output %q for multibyte runes, want %q", got, want)
+	}
+	got, _, _ = runFz(t, "ab main.go\n", "-context", "5", "main")
+	if want := "ab \033[1mmain\033[0m.go\n"; got != want {
+		t.Errorf("got output %q for a short line, want %q", got, want)
+	}
+
+	// Colors from before the window still apply, and are reset at the
+	// end.
+	got, _, _ = runFz(t, "\033[31mxxxxxx main\033[0m xxxxxx\n", "-preserve-ansi", "-context", "1", "main")
+	if want := "\033[31m… \033[1mmain\033[0m\033[31m\033[0m …\033[0m\n"; got != want {
+		t.Errorf("got output %q with escapes, want %q", got, want)
+	}
+}
+
+func TestReverse(t *testing.T) {
+	stdin := "people\nply\nplace\ndog\napple\n"
+	want, _, _ := runFz(t, stdin, "-color", "never", "pl")
+	got, _, code := runFz(t, stdin, "-reverse", "-color", "never", "pl")
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	lines := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	for i, j := 0, len(lines)-1; i < j; i, j = i+1, j-1 {
+		lines[i], lines[j] = lines[j], lines[i]
+	}
+	if reversed := strings.Join(lines, "\n") + "\n"; got != reversed {
+		t.Errorf("got output %q, want the reverse of %q", got, want)
+	}
+	if len(lines) < 3 {
+		t.Errorf("got %d results, want at least 3 to reverse", len(lines))
+	}
+}
+
+func TestLongLine(t *testing.T) {
+	// The line is longer than bufio.Scanner's default limit, and so
+	// degenerate that it's only aligned greedily.
+	long := strings.Repeat("a", 100000)
+	term := strings.Repeat("a", 500) + "b"
+	var got string
+	var code int
+	done := make(chan struct{})
+	go func() {
+		got, _, code = runFz(t, long+"\nab\n", "-color", "never", term)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("got no results for a long line, want it to return quickly")
+	}
+	if code != 0 {
+		t.Fatalf("got exit code %d, want 0", code)
+	}
+	if want := long + "\nab\n"; got != want {
+		t.Errorf("got output of %d bytes, want both lines", len(got))
+	}
+
+	path := writeFile(t, "long.txt", long+"b\n")
+	if got, _, code := runFz(t, "", "-color", "never", "-cache-dir", t.TempDir(), "-f", path, "ab"); code != 0 || got != long+"b\n" {
+		t.Errorf("got exit code %d and %d bytes with a cache, want the long line", code, len(got))
+	}
+}
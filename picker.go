@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"unicode"
+
+	"github.com/gcurtis/fz/fuzzy"
+)
+
+// terminal is where an interactive selection is made. Keys are read from in,
+// and the picker is drawn on out.
+type terminal struct {
+	in  io.Reader
+	out io.Writer
+
+	// size returns the terminal's size in rows and columns.
+	size func() (rows, cols int)
+
+	// resized receives a value whenever the terminal's size changes.
+	resized <-chan os.Signal
+
+	// close restores the terminal to the state it was in before it was
+	// opened.
+	close func() error
+}
+
+// openTerminal opens the terminal used by -interactive. It's a variable so
+// that tests can replace it.
+var openTerminal = openTTY
+
+// Keys that don't correspond to a single rune are sent as negative runes.
+const (
+	keyUp rune = -1 - iota
+	keyDown
+)
+
+// readKeys sends each key read from r to keys, and closes keys once r can't
+// be read any more.
+func readKeys(r io.Reader, keys chan<- rune) {
+	defer close(keys)
+	br := bufio.NewReader(r)
+	for {
+		k, _, err := br.ReadRune()
+		if err != nil {
+			return
+		}
+		if k == '\033' {
+			// Only the arrow keys' sequences are recognized, and the
+			// rest are dropped along with their escape.
+			intro, _, err := br.ReadRune()
+			if err != nil {
+				return
+			}
+			final, _, err := br.ReadRune()
+			if err != nil {
+				return
+			}
+			switch {
+			case (intro == '[' || intro == 'O') && final == 'A':
+				k = keyUp
+			case (intro == '[' || intro == 'O') && final == 'B':
+				k = keyDown
+			default:
+				continue
+			}
+		}
+		keys <- k
+	}
+}
+
+// picker is the state of an interactive selection from a corpus.
+type picker struct {
+	// search ranks the corpus against a term, keeping at most max results.
+	search func(term string, max int) []fuzzy.Result
+
+	// highlight is the escape sequence that starts a highlighted match, or
+	// empty to draw results without highlighting.
+	highlight string
+
+	query      []rune
+	results    []fuzzy.Result
+	selected   int
+	rows, cols int
+}
+
+// update searches the corpus for the current query, keeping as many results
+// as there are rows to show them in. Without a query, the search passes the
+// corpus through in its original order.
+func (p *picker) update() {
+	max := p.rows - 1
+	if max < 1 {
+		max = 1
+	}
+	p.results = p.search(string(p.query), max)
+	if p.selected >= len(p.results) {
+		p.selected = len(p.results) - 1
+	}
+	if p.selected < 0 {
+		p.selected = 0
+	}
+}
+
+// draw clears w and draws the query on the first row followed by a result on
+// each of the rows after it. The selected result is marked with a ">".
+func (p *picker) draw(w io.Writer) {
+	var buf bytes.Buffer
+	buf.WriteString("\033[H\033[2J> ")
+	buf.WriteString(string(p.query))
+	for i, r := range p.results {
+		buf.WriteString("\r\n")
+		if i == p.selected {
+			buf.WriteString("> ")
+		} else {
+			buf.WriteString("  ")
+		}
+		r = clip(r, p.cols-2)
+		if p.highlight != "" {
+			printHighlight(&buf, r, p.highlight)
+		} else {
+			printPlain(&buf, r)
+		}
+		if len(r.Escapes) > 0 {
+			buf.WriteString("\033[0m")
+		}
+	}
+	fmt.Fprintf(&buf, "\033[1;%dH", len(p.query)+3)
+	buf.WriteTo(w)
+}
+
+// clip returns r with its input cut down to fit in n columns. Spans and escapes
+// past the cut are dropped or shortened to fit.
+func clip(r fuzzy.Result, n int) fuzzy.Result {
+	end := len(r.Input)
+	for i, c := range r.Input {
+		w := runeWidth(c)
+		if w > n {
+			end = i
+			break
+		}
+		n -= w
+	}
+	if end == len(r.Input) {
+		return r
+	}
+
+	r.Input = r.Input[:end]
+	r.Matches = clipSpans(r.Matches, end)
+	r.Extra = clipSpans(r.Extra, end)
+	var escapes []fuzzy.Escape
+	for _, e := range r.Escapes {
+		if e.Pos <= end {
+			escapes = append(escapes, e)
+		}
+	}
+	r.Escapes = escapes
+	return r
+}
+
+// columns returns the number of columns that a terminal displays s in.
+func columns(s string) int {
+	n := 0
+	for _, c := range s {
+		n += runeWidth(c)
+	}
+	return n
+}
+
+// runeWidth returns the number of columns that a terminal displays c in.
+// Combining marks take none, and East Asian wide runes take two.
+func runeWidth(c rune) int {
+	switch {
+	case unicode.In(c, unicode.Mn, unicode.Me, unicode.Cf):
+		return 0
+	case unicode.In(c, unicode.Han, unicode.Hangul, unicode.Hiragana, unicode.Katakana),
+		c >= 0xff01 && c <= 0xff60, c >= 0x3000 && c <= 0x303f:
+		return 2
+	}
+	return 1
+}
+
+// clipSpans returns the parts of spans that end at or before the byte offset
+// end.
+func clipSpans(spans []fuzzy.Span, end int) []fuzzy.Span {
+	var clipped []fuzzy.Span
+	for _, s := range spans {
+		if s.Start >= end {
+			break
+		}
+		if s.End > end {
+			s.End = end
+		}
+		clipped = append(clipped, s)
+	}
+	return clipped
+}
+
+// pick runs an interactive selection on t until a result is chosen with
+// Enter, which returns its input, or the selection is aborted with Ctrl-C,
+// which returns false. Typing refines the query and the up and down arrows
+// move the selection.
+func pick(t *terminal, p *picker) (string, bool) {
+	keys := make(chan rune)
+	go readKeys(t.in, keys)
+	p.rows, p.cols = t.size()
+	p.update()
+	for {
+		p.draw(t.out)
+		select {
+		case k, ok := <-keys:
+			if !ok {
+				return "", false
+			}
+			switch {
+			case k == 0x03: // Ctrl-C
+				return "", false
+			case k == '\r' || k == '\n':
+				if len(p.results) > 0 {
+					return p.results[p.selected].Input, true
+				}
+			case k == keyUp || k == 0x10: // Ctrl-P
+				if p.selected > 0 {
+					p.selected--
+				}
+			case k == keyDown || k == 0x0e: // Ctrl-N
+				if p.selected < len(p.results)-1 {
+					p.selected++
+				}
+			case k == 0x7f || k == 0x08: // Backspace
+				if len(p.query) > 0 {
+					p.query = p.query[:len(p.query)-1]
+					p.selected = 0
+					p.update()
+				}
+			case k == 0x15: // Ctrl-U
+				p.query = nil
+				p.selected = 0
+				p.update()
+			case unicode.IsPrint(k):
+				p.query = append(p.query, k)
+				p.selected = 0
+				p.update()
+			}
+		case <-t.resized:
+			p.rows, p.cols = t.size()
+			p.update()
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gcurtis/fz/fuzzy"
+)
+
+func TestInteractive(t *testing.T) {
+	defer func(open func() (*terminal, error)) { openTerminal = open }(openTerminal)
+	var screen bytes.Buffer
+	interact := func(keys string, args ...string) (string, int) {
+		t.Helper()
+		screen.Reset()
+		openTerminal = func() (*terminal, error) {
+			return &terminal{
+				in:    strings.NewReader(keys),
+				out:   &screen,
+				size:  func() (int, int) { return 10, 40 },
+				close: func() error { return nil },
+			}, nil
+		}
+		stdout, _, code := runFz(t, "people\nperson\nplace\nply\ndog\n", append([]string{"-interactive"}, args...)...)
+		return stdout, code
+	}
+
+	for _, tt := range []struct {
+		keys string
+		want string
+	}{
+		{"pl\r", "ply\n"},
+		{"pl\033[B\r", "place\n"},
+		{"pl\033[B\033[B\033[A\r", "place\n"},
+		{"x\x7fdog\r", "dog\n"},
+		{"\r", "people\n"},
+	} {
+		if got, code := interact(tt.keys); got != tt.want || code != 0 {
+			t.Errorf("got %q with exit code %d for keys %q, want %q", got, code, tt.keys, tt.want)
+		}
+	}
+	if got, code := interact("\x0e\r", "-query", "pl"); got != "place\n" || code != 0 {
+		t.Errorf("got %q with exit code %d starting from -query, want place", got, code)
+	}
+	if got, code := interact("\x0e\r", "pl"); got != "place\n" || code != 0 {
+		t.Errorf("got %q with exit code %d starting from the search argument, want place", got, code)
+	}
+
+	// The picker's rows limit the results rather than -n.
+	if got, code := interact("pl\033[B\r", "-n", "1", "-parallel-merge", "-batch-bytes", "10"); got != "place\n" || code != 0 {
+		t.Errorf("got %q with exit code %d with -n 1 and -parallel-merge, want place", got, code)
+	}
+
+	if got, code := interact("pl\x03"); got != "" || code != 130 {
+		t.Errorf("got %q with exit code %d after Ctrl-C, want nothing with 130", got, code)
+	}
+	if !strings.Contains(screen.String(), "> \033[1mpl\033[0my") {
+		t.Errorf("got screen %q, want the selected result highlighted", screen.String())
+	}
+}
+
+func TestClip(t *testing.T) {
+	r, _ := fuzzy.Match("abcdéfg", "adf")
+	got := clip(r, 5)
+	if got.Input != "abcdé" || fmt.Sprint(got.Matches) != "[{0 1} {3 4}]" {
+		t.Errorf("got %q with spans %v, want abcdé with the spans before the cut", got.Input, got.Matches)
+	}
+	if got := clip(r, 10); got.Input != r.Input {
+		t.Errorf("got %q for an input that fits, want it unchanged", got.Input)
+	}
+}
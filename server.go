@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/gcurtis/fz/fuzzy"
+)
+
+// The server protocol is line based. A client sends the most results it wants,
+// or 0 for no limit, and a search term, separated by a space and terminated by
+// a newline. The server replies with a line holding the number of results, the
+// number of inputs that matched and the number of inputs searched, followed by
+// each ranked input on its own line. A client may send any number of searches
+// over a single connection.
+
+// server answers searches against a corpus held in memory, so that it only
+// needs to be read once.
+type server struct {
+	corpus []string
+
+	// newSearcher returns a searcher for a term that's asked for at most
+	// max results, configured the same way as it would be for a single
+	// search.
+	newSearcher func(term string, max int) *fuzzy.Searcher
+}
+
+// reply is a server's answer to a search.
+type reply struct {
+	inputs  []string
+	matched int
+	total   int
+}
+
+// listenAndServe loads the corpus from path and answers searches on a unix
+// socket until the process is interrupted.
+func listenAndServe(socket, path string, newSearcher func(string, int) *fuzzy.Searcher) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	srv := server{newSearcher: newSearcher}
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	scanner := newScanner(r)
+	for scanner.Scan() {
+		srv.corpus = append(srv.corpus, scanner.Text())
+	}
+	f.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+
+	// Closing the listener removes the socket file.
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		l.Close()
+	}()
+
+	err = srv.serve(l)
+	if errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// serve accepts connections on l until it's closed.
+func (srv *server) serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.handle(conn)
+	}
+}
+
+func (srv *server) handle(conn net.Conn) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(conn)
+	w := bufio.NewWriter(conn)
+	for scanner.Scan() {
+		req := scanner.Text()
+		i := strings.IndexByte(req, ' ')
+		if i == -1 {
+			return
+		}
+		max, err := strconv.Atoi(req[:i])
+		if err != nil || max < 0 {
+			return
+		}
+		s := srv.newSearcher(req[i+1:], max)
+		for _, l := range srv.corpus {
+			s.Append(l)
+		}
+		results := s.RankedResults(max)
+
+		fmt.Fprintln(w, len(results), s.Matched(), s.Total())
+		for _, r := range results {
+			w.WriteString(r.Input)
+			w.WriteByte('\n')
+		}
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// dialAndQuery sends a single search for up to max results to the server
+// listening on socket.
+func dialAndQuery(socket, term string, max int) (reply, error) {
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return reply{}, err
+	}
+	defer conn.Close()
+	return query(conn, bufio.NewReader(conn), term, max)
+}
+
+// query sends a search for up to max results to a server and reads back its
+// reply.
+func query(w io.Writer, r *bufio.Reader, term string, max int) (reply, error) {
+	if strings.ContainsAny(term, "\r\n") {
+		return reply{}, errors.New("search term can't contain line breaks when querying a server")
+	}
+	if _, err := fmt.Fprintf(w, "%d %s\n", max, term); err != nil {
+		return reply{}, err
+	}
+
+	header, err := r.ReadString('\n')
+	if err != nil {
+		return reply{}, err
+	}
+	var rep reply
+	var n int
+	if _, err := fmt.Sscanf(header, "%d %d %d\n", &n, &rep.matched, &rep.total); err != nil {
+		return reply{}, fmt.Errorf("invalid response from server: %q", header)
+	}
+	rep.inputs = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return reply{}, err
+		}
+		rep.inputs = append(rep.inputs, strings.TrimSuffix(line, "\n"))
+	}
+	return rep, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gcurtis/fz/fuzzy"
+)
+
+func TestServer(t *testing.T) {
+	l, err := net.Listen("unix", filepath.Join(t.TempDir(), "fz.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	srv := server{
+		corpus:      []string{"people", "person", "place", "ply", "dog"},
+		newSearcher: func(term string, max int) *fuzzy.Searcher { return fuzzy.New(term) },
+	}
+	go srv.serve(l)
+
+	conn, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// Several searches are answered in turn over the same connection.
+	for _, tt := range []struct {
+		term    string
+		max     int
+		want    []string
+		matched int
+	}{
+		{"pl", maxResults, []string{"ply", "place", "people", "person"}, 4},
+		{"dog", maxResults, []string{"dog"}, 1},
+		{"xyz", maxResults, []string{}, 0},
+		{"pers", maxResults, []string{"person", "people", "place", "ply"}, 4},
+		{"pl", 2, []string{"ply", "place"}, 4},
+	} {
+		rep, err := query(conn, r, tt.term, tt.max)
+		if err != nil {
+			t.Fatalf("query %q: %v", tt.term, err)
+		}
+		if strings.Join(rep.inputs, ",") != strings.Join(tt.want, ",") || rep.matched != tt.matched || rep.total != len(srv.corpus) {
+			t.Errorf("got results %q, %d matched and %d total for %q with max %d, want %q, %d and %d", rep.inputs, rep.matched, rep.total, tt.term, tt.max, tt.want, tt.matched, len(srv.corpus))
+		}
+	}
+
+	// The client's output flags apply to the server's results.
+	socket := l.Addr().String()
+	for _, tt := range []struct {
+		args       []string
+		out, diags string
+	}{
+		{[]string{"-query", "dog"}, "\033[1mdog\033[0m\n", ""},
+		{[]string{"-n", "2", "-color", "never", "-footer", "-query", "pl"}, "ply\nplace\n", "2/4 matches\n"},
+		{[]string{"-c", "-query", "pl"}, "4\n", ""},
+		{[]string{"-reverse", "-n", "2", "-color", "never", "-query", "pl"}, "place\nply\n", ""},
+		{[]string{"-format", "jsonl", "-query", "dog"}, `{"input":"dog","matchScore":3,"gapScore":0,"normalizedScore":1,"spans":[{"start":0,"end":3}]}` + "\n", ""},
+	} {
+		got, diags, code := runFz(t, "", append([]string{"-connect", socket}, tt.args...)...)
+		if code != 0 || got != tt.out || diags != tt.diags {
+			t.Errorf("got client output %q and %q with exit code %d for %q, want %q and %q", got, diags, code, tt.args, tt.out, tt.diags)
+		}
+	}
+
+	if _, _, code := runFz(t, "", "-connect", socket, "-stats", "-query", "pl"); code != 2 {
+		t.Errorf("got exit code %d for -connect with -stats, want 2", code)
+	}
+}
+
+func TestServerLimit(t *testing.T) {
+	dir := t.TempDir()
+	var corpus strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&corpus, "line%03d\n", i)
+	}
+	path := filepath.Join(dir, "corpus")
+	if err := os.WriteFile(path, []byte(corpus.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server runs until the test binary exits, since it's only
+	// stopped by a signal.
+	socket := filepath.Join(dir, "fz.sock")
+	go runFz(t, "", "-serve", socket, "-parallel-merge", "-batch-bytes", "500", "-n", "1", path)
+	var conn net.Conn
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		var err error
+		if conn, err = net.Dial("unix", socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	// The client's limit applies rather than the server's -n.
+	for _, max := range []int{100, 0, 5} {
+		rep, err := query(conn, r, "line", max)
+		if err != nil {
+			t.Fatalf("query with max %d: %v", max, err)
+		}
+		want := max
+		if max == 0 {
+			want = 200
+		}
+		if len(rep.inputs) != want {
+			t.Errorf("got %d results with max %d, want %d", len(rep.inputs), max, want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !unix && !windows
+// +build !unix,!windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// openTTY always fails, since raw terminal input is only supported on Unix.
+func openTTY() (*terminal, error) {
+	return nil, errors.New("-interactive isn't supported on this platform")
+}
+
+// ttyColumns always returns 0, since the terminal's width isn't known.
+func ttyColumns(tty *os.File) int {
+	return 0
+}
+
+// enableEscapes always reports true, since there's no console that needs to be
+// asked to interpret escape sequences.
+func enableEscapes(w io.Writer) bool {
+	return true
+}
@@ -0,0 +1,85 @@
+//go:build unix
+// +build unix
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+// openTTY opens the controlling terminal in raw mode, so that keys are read as
+// they're typed without being echoed. Stdin and stdout are left alone, since
+// they're usually the corpus and wherever the selection is going.
+func openTTY() (*terminal, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := stty(tty, "-g")
+	if err != nil {
+		tty.Close()
+		return nil, err
+	}
+	if _, err := stty(tty, "raw", "-echo"); err != nil {
+		tty.Close()
+		return nil, err
+	}
+
+	resized := make(chan os.Signal, 1)
+	signal.Notify(resized, syscall.SIGWINCH)
+	return &terminal{
+		in:  tty,
+		out: tty,
+		size: func() (rows, cols int) {
+			out, err := stty(tty, "size")
+			if err != nil {
+				return 24, 80
+			}
+			if _, err := fmt.Sscan(out, &rows, &cols); err != nil || rows < 1 || cols < 1 {
+				return 24, 80
+			}
+			return rows, cols
+		},
+		resized: resized,
+		close: func() error {
+			signal.Stop(resized)
+			_, err := stty(tty, strings.TrimSpace(saved))
+			tty.Close()
+			return err
+		},
+	}, nil
+}
+
+// ttyColumns returns the number of columns of the terminal tty, or 0 if it
+// can't be found.
+func ttyColumns(tty *os.File) int {
+	out, err := stty(tty, "size")
+	if err != nil {
+		return 0
+	}
+	var rows, cols int
+	if _, err := fmt.Sscan(out, &rows, &cols); err != nil || cols < 1 {
+		return 0
+	}
+	return cols
+}
+
+// enableEscapes always reports true, since terminals other than the Windows
+// console interpret escape sequences without being asked to.
+func enableEscapes(w io.Writer) bool {
+	return true
+}
+
+// stty runs stty with args against tty and returns what it printed.
+func stty(tty *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = tty
+	out, err := cmd.Output()
+	return string(out), err
+}
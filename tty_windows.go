@@ -0,0 +1,49 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// enableVirtualTerminalProcessing is the console mode that makes the console
+// interpret ANSI escape sequences rather than printing them.
+const enableVirtualTerminalProcessing = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// openTTY always fails, since raw terminal input isn't supported on Windows.
+func openTTY() (*terminal, error) {
+	return nil, errors.New("-interactive isn't supported on Windows")
+}
+
+// ttyColumns always returns 0, since the terminal's width isn't known on
+// Windows.
+func ttyColumns(tty *os.File) int {
+	return 0
+}
+
+// enableEscapes turns on virtual terminal processing for the console that w
+// writes to, so that highlights are displayed rather than printed as escape
+// sequences. It reports whether it succeeded, which it doesn't for older
+// consoles or when w isn't a console.
+func enableEscapes(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	h := syscall.Handle(f.Fd())
+	var mode uint32
+	if err := syscall.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&enableVirtualTerminalProcessing != 0 {
+		return true
+	}
+	r, _, _ := setConsoleMode.Call(uintptr(h), uintptr(mode|enableVirtualTerminalProcessing))
+	return r != 0
+}